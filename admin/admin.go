@@ -0,0 +1,285 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// Default is the registry the scaler loops register with.
+var Default = NewRegistry()
+
+// Registry holds the overrides operators set on the scaler loops through the
+// admin API. Overrides only live in memory.
+type Registry struct {
+	Clock clock.PassiveClock
+
+	mu      sync.Mutex
+	targets map[string]*Target
+}
+
+func NewRegistry() *Registry {
+	return &Registry{Clock: clock.RealClock{}, targets: map[string]*Target{}}
+}
+
+// Register returns the target with the given namespace/deployment name,
+// creating it on first use. Overrides survive a restart of the loop, e.g.
+// after leadership moved back to this replica.
+func Register(name string) *Target {
+	return Default.Register(name)
+}
+
+func (r *Registry) Register(name string) *Target {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, ok := r.targets[name]; ok {
+		return t
+	}
+	t := &Target{name: name, evaluations: make(chan struct{}, 1)}
+	r.targets[name] = t
+	return t
+}
+
+// lookup finds a target by namespace/name or by name if that's unique.
+func (r *Registry) lookup(deployment string) (*Target, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, ok := r.targets[deployment]; ok {
+		return t, nil
+	}
+	var found *Target
+	for name, t := range r.targets {
+		if name[strings.LastIndex(name, "/")+1:] != deployment {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("deployment %q is ambiguous, use namespace/name", deployment)
+		}
+		found = t
+	}
+	if found == nil {
+		return nil, fmt.Errorf("deployment %q isn't scaled by this autoscaler", deployment)
+	}
+	return found, nil
+}
+
+func (r *Registry) statuses() []TargetStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.Clock.Now()
+	statuses := []TargetStatus{}
+	for _, t := range r.targets {
+		statuses = append(statuses, t.status(now))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Target is the overrides of a single scaler loop.
+type Target struct {
+	name        string
+	evaluations chan struct{}
+
+	mu          sync.Mutex
+	paused      bool
+	pausedUntil time.Time
+	replicas    *int32
+	pinnedUntil time.Time
+}
+
+// Override is what an operator asked for. Expired overrides are left out.
+type Override struct {
+	Paused      bool       `json:"paused"`
+	PausedUntil *time.Time `json:"pausedUntil,omitempty"`
+	Replicas    *int32     `json:"replicas,omitempty"`
+	PinnedUntil *time.Time `json:"pinnedUntil,omitempty"`
+}
+
+// Override returns the overrides in effect at now. It's safe on a nil target.
+func (t *Target) Override(now time.Time) Override {
+	if t == nil {
+		return Override{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	o := Override{}
+	if t.paused && (t.pausedUntil.IsZero() || now.Before(t.pausedUntil)) {
+		o.Paused = true
+		if !t.pausedUntil.IsZero() {
+			until := t.pausedUntil
+			o.PausedUntil = &until
+		}
+	}
+	if t.replicas != nil && now.Before(t.pinnedUntil) {
+		replicas, until := *t.replicas, t.pinnedUntil
+		o.Replicas, o.PinnedUntil = &replicas, &until
+	}
+	return o
+}
+
+// Pause stops scaling until Resume, or until the given time when it isn't
+// zero.
+func (t *Target) Pause(until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.paused, t.pausedUntil = true, until
+}
+
+// Pin keeps the deployment at replicas until the given time.
+func (t *Target) Pin(replicas int32, until time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.replicas, t.pinnedUntil = &replicas, until
+}
+
+// Resume clears every override.
+func (t *Target) Resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.paused, t.pausedUntil = false, time.Time{}
+	t.replicas, t.pinnedUntil = nil, time.Time{}
+}
+
+// EvaluateNow asks the loop to evaluate right away, regardless of the poll
+// interval and cooldowns. Requests made while one is pending are merged.
+func (t *Target) EvaluateNow() {
+	select {
+	case t.evaluations <- struct{}{}:
+	default:
+	}
+}
+
+// Evaluations receives the EvaluateNow requests. It's nil, so never ready, on
+// a nil target.
+func (t *Target) Evaluations() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.evaluations
+}
+
+// TargetStatus is the body of the admin responses.
+type TargetStatus struct {
+	Name     string   `json:"name"`
+	Override Override `json:"override"`
+}
+
+func (t *Target) status(now time.Time) TargetStatus {
+	return TargetStatus{Name: t.name, Override: t.Override(now)}
+}
+
+// Handler serves the admin API under /admin/. Every request needs token as a
+// bearer token.
+//
+//	GET  /admin/targets
+//	POST /admin/pause?deployment=<name>[&duration=<duration>]
+//	POST /admin/resume?deployment=<name>
+//	POST /admin/pin?deployment=<name>&replicas=<n>&duration=<duration>
+//	POST /admin/evaluate?deployment=<name>
+func (r *Registry) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/targets", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, r.statuses())
+	})
+	mux.HandleFunc("/admin/pause", r.targetHandler(func(t *Target, req *http.Request) (int, error) {
+		var until time.Time
+		if d := req.URL.Query().Get("duration"); d != "" {
+			duration, err := parseDuration(d)
+			if err != nil {
+				return http.StatusBadRequest, err
+			}
+			until = r.Clock.Now().Add(duration)
+		}
+		t.Pause(until)
+		return http.StatusOK, nil
+	}))
+	mux.HandleFunc("/admin/resume", r.targetHandler(func(t *Target, req *http.Request) (int, error) {
+		t.Resume()
+		return http.StatusOK, nil
+	}))
+	mux.HandleFunc("/admin/pin", r.targetHandler(func(t *Target, req *http.Request) (int, error) {
+		replicas, err := strconv.ParseInt(req.URL.Query().Get("replicas"), 10, 32)
+		if err != nil || replicas < 0 {
+			return http.StatusBadRequest, fmt.Errorf("replicas must be a non-negative number")
+		}
+		duration, err := parseDuration(req.URL.Query().Get("duration"))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		t.Pin(int32(replicas), r.Clock.Now().Add(duration))
+		// apply the pin without waiting for the next poll
+		t.EvaluateNow()
+		return http.StatusOK, nil
+	}))
+	mux.HandleFunc("/admin/evaluate", r.targetHandler(func(t *Target, req *http.Request) (int, error) {
+		t.EvaluateNow()
+		return http.StatusAccepted, nil
+	}))
+	return authenticated(token, mux)
+}
+
+// targetHandler looks up the target of a POST request and responds with its
+// status after f changed it.
+func (r *Registry) targetHandler(f func(*Target, *http.Request) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		t, err := r.lookup(req.URL.Query().Get("deployment"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		code, err := f(t, req)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+		writeJSON(w, code, t.status(r.Clock.Now()))
+	}
+}
+
+func authenticated(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("duration must be a positive duration like 30m, got %q", s)
+	}
+	return d, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
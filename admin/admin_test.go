@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func newFakeRegistry() (*Registry, *clock.FakeClock) {
+	clk := clock.NewFakeClock(time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC))
+	r := NewRegistry()
+	r.Clock = clk
+	return r, clk
+}
+
+func request(h http.Handler, method, url, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerNeedsToken(t *testing.T) {
+	r, _ := newFakeRegistry()
+	h := r.Handler("secret")
+
+	assert.Equal(t, http.StatusUnauthorized, request(h, http.MethodGet, "/admin/targets", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(h, http.MethodGet, "/admin/targets", "wrong").Code)
+	assert.Equal(t, http.StatusOK, request(h, http.MethodGet, "/admin/targets", "secret").Code)
+}
+
+func TestPauseAndResume(t *testing.T) {
+	r, clk := newFakeRegistry()
+	target := r.Register("namespace/deploy")
+	h := r.Handler("secret")
+
+	rec := request(h, http.MethodPost, "/admin/pause?deployment=deploy&duration=1m", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var status TargetStatus
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, "namespace/deploy", status.Name)
+	assert.True(t, status.Override.Paused)
+	assert.True(t, target.Override(clk.Now()).Paused)
+
+	// the pause expires
+	clk.Step(time.Minute)
+	assert.False(t, target.Override(clk.Now()).Paused)
+
+	assert.Equal(t, http.StatusOK, request(h, http.MethodPost, "/admin/pause?deployment=namespace/deploy", "secret").Code)
+	clk.Step(time.Hour)
+	assert.True(t, target.Override(clk.Now()).Paused)
+	assert.Equal(t, http.StatusOK, request(h, http.MethodPost, "/admin/resume?deployment=deploy", "secret").Code)
+	assert.False(t, target.Override(clk.Now()).Paused)
+}
+
+func TestPin(t *testing.T) {
+	r, clk := newFakeRegistry()
+	target := r.Register("namespace/deploy")
+	h := r.Handler("secret")
+
+	assert.Equal(t, http.StatusBadRequest, request(h, http.MethodPost, "/admin/pin?deployment=deploy&replicas=-1&duration=1m", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, request(h, http.MethodPost, "/admin/pin?deployment=deploy&replicas=3", "secret").Code)
+
+	assert.Equal(t, http.StatusOK, request(h, http.MethodPost, "/admin/pin?deployment=deploy&replicas=3&duration=1m", "secret").Code)
+	assert.Equal(t, int32(3), *target.Override(clk.Now()).Replicas)
+	// pinning evaluates right away
+	assert.Len(t, target.Evaluations(), 1)
+
+	clk.Step(time.Minute)
+	assert.Nil(t, target.Override(clk.Now()).Replicas)
+}
+
+func TestEvaluate(t *testing.T) {
+	r, _ := newFakeRegistry()
+	target := r.Register("namespace/deploy")
+	h := r.Handler("secret")
+
+	assert.Equal(t, http.StatusMethodNotAllowed, request(h, http.MethodGet, "/admin/evaluate?deployment=deploy", "secret").Code)
+	assert.Equal(t, http.StatusAccepted, request(h, http.MethodPost, "/admin/evaluate?deployment=deploy", "secret").Code)
+	assert.Equal(t, http.StatusAccepted, request(h, http.MethodPost, "/admin/evaluate?deployment=deploy", "secret").Code)
+	assert.Len(t, target.Evaluations(), 1, "pending evaluations are merged")
+}
+
+func TestLookup(t *testing.T) {
+	r, _ := newFakeRegistry()
+	r.Register("first/deploy")
+	r.Register("second/deploy")
+	h := r.Handler("secret")
+
+	assert.Equal(t, http.StatusNotFound, request(h, http.MethodPost, "/admin/pause?deployment=deploy", "secret").Code)
+	assert.Equal(t, http.StatusNotFound, request(h, http.MethodPost, "/admin/pause?deployment=unknown", "secret").Code)
+	assert.Equal(t, http.StatusOK, request(h, http.MethodPost, "/admin/pause?deployment=second/deploy", "secret").Code)
+}
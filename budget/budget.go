@@ -0,0 +1,313 @@
+package budget
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// Default is the coordinator the scaler loops join.
+var Default = NewCoordinator()
+
+// DefaultReportTimeout is how long deployments wait for another one that
+// joined to report its replicas before they scale up without it.
+const DefaultReportTimeout = 30 * time.Second
+
+// Limits are the budgets of all deployments or of a group. 0 is no limit.
+type Limits struct {
+	MaxPods int
+	// MaxHourlySpend caps the replicas times their hourly cost.
+	MaxHourlySpend float64
+}
+
+// Coordinator shares replica budgets between the scaled deployments. While
+// the deployments want more than the Total limits, or than the limits of
+// their group in Groups, the budget is allocated proportionally to the
+// replicas each one wants, weighted by its priority.
+type Coordinator struct {
+	Total  Limits
+	Groups map[string]Limits
+	// ReportTimeout bounds how long scaling up waits for deployments that
+	// joined but didn't report their replicas yet, e.g. because their
+	// deployment can't be read.
+	ReportTimeout time.Duration
+	// Clock is the wall clock without one.
+	Clock clock.PassiveClock
+
+	mu      sync.Mutex
+	members map[string]*Member
+}
+
+func NewCoordinator() *Coordinator {
+	return &Coordinator{Groups: map[string]Limits{}, ReportTimeout: DefaultReportTimeout, members: map[string]*Member{}}
+}
+
+// Member is a deployment sharing the budgets. A nil *Member isn't limited.
+type Member struct {
+	c        *Coordinator
+	name     string
+	group    string
+	priority int
+	cost     float64
+
+	// want is what the deployment asked for last, replicas what it has.
+	// replicas are unknown until it reported them the first time.
+	want     int32
+	replicas int32
+	reported bool
+	joined   time.Time
+}
+
+// Join adds the deployment with the given name to the Default coordinator.
+func Join(name, group string, priority int, podHourlyCost float64) *Member {
+	return Default.Join(name, group, priority, podHourlyCost)
+}
+
+// Join adds the deployment with the given name to the budgets of its group,
+// none for "", and to the total budgets. Priorities below 1 count as 1. Pods
+// without an hourly cost don't count against spend caps.
+func (c *Coordinator) Join(name, group string, priority int, podHourlyCost float64) *Member {
+	if priority < 1 {
+		priority = 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := &Member{c: c, name: name, group: group, priority: priority, cost: podHourlyCost, joined: c.now()}
+	c.members[name] = m
+	return m
+}
+
+// Leave removes the deployment from the budgets, e.g. when its loop stopped.
+func (m *Member) Leave() {
+	if m == nil {
+		return
+	}
+	m.c.mu.Lock()
+	defer m.c.mu.Unlock()
+
+	if m.c.members[m.name] == m {
+		delete(m.c.members, m.name)
+	}
+}
+
+// Report records that the deployment has replicas. Until it reported them
+// the first time the other deployments don't scale up into the budgets it
+// shares, unless it takes longer than the ReportTimeout.
+func (m *Member) Report(replicas int32) {
+	if m == nil {
+		return
+	}
+	m.c.mu.Lock()
+	defer m.c.mu.Unlock()
+
+	m.replicas, m.reported = replicas, true
+}
+
+// HourlySpend is what replicas of the deployment cost per hour, ok is false
+// when it has no hourly cost.
+func (m *Member) HourlySpend(replicas int32) (spend float64, ok bool) {
+	if m == nil || m.cost == 0 {
+		return 0, false
+	}
+	return float64(replicas) * m.cost, true
+}
+
+// Allocate records that the deployment has current replicas and wants
+// desired, and returns how many it may have. The detail explains why when
+// that's less than desired. Scaling up is also limited to the budget the
+// other deployments leave unused, so the budget holds while they still scale
+// down to their share, and to current until every deployment sharing the
+// budget reported its replicas, see Report.
+func (m *Member) Allocate(current, desired int32) (int32, string) {
+	if m == nil {
+		return desired, ""
+	}
+	c := m.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m.want, m.replicas, m.reported = desired, current, true
+	now := c.now()
+	all := c.sortedMembers()
+	wants := map[*Member]int32{}
+	for _, member := range all {
+		wants[member] = member.want
+	}
+
+	// every group is held to its budgets before the total budgets are shared
+	for group, limits := range c.Groups {
+		for _, b := range groupBudgets(group, limits) {
+			allocate(c.groupMembers(group), wants, b)
+		}
+	}
+
+	granted, detail := desired, ""
+	limit := func(members []*Member, b budget) {
+		allocate(members, wants, b)
+		share := wants[m]
+		// what the others have counts against the budget until they scaled
+		// down to their share
+		if free, ok := b.free(m, members); ok && share > current && share > free {
+			share = free
+			if share < current {
+				share = current
+			}
+		}
+		if share < granted {
+			granted = share
+			detail = fmt.Sprintf("%d capped to %d replicas by %s", desired, granted, b.name)
+		}
+		// what the others have is unknown until they reported it
+		if granted > current && b.cost(m) > 0 && c.waitingFor(members, b, now) {
+			granted = current
+			detail = fmt.Sprintf("%d capped to %d replicas by %s until every deployment sharing it reported its replicas", desired, granted, b.name)
+		}
+	}
+	if m.group != "" {
+		for _, b := range groupBudgets(m.group, c.Groups[m.group]) {
+			limit(c.groupMembers(m.group), b)
+		}
+	}
+	for _, b := range totalBudgets(c.Total) {
+		limit(all, b)
+	}
+	return granted, detail
+}
+
+// Scaled records that the deployment was scaled to replicas.
+func (m *Member) Scaled(replicas int32) {
+	if m == nil {
+		return
+	}
+	m.c.mu.Lock()
+	defer m.c.mu.Unlock()
+
+	m.replicas = replicas
+}
+
+// budget is a limit on the replicas of some deployments, each replica costs
+// cost of it.
+type budget struct {
+	name  string
+	limit float64
+	cost  func(*Member) float64
+}
+
+func podCount(*Member) float64     { return 1 }
+func hourlyCost(m *Member) float64 { return m.cost }
+
+func totalBudgets(limits Limits) []budget {
+	budgets := []budget{}
+	if limits.MaxPods > 0 {
+		budgets = append(budgets, budget{fmt.Sprintf("maxTotalPods of %d pods", limits.MaxPods), float64(limits.MaxPods), podCount})
+	}
+	if limits.MaxHourlySpend > 0 {
+		budgets = append(budgets, budget{fmt.Sprintf("maxHourlySpend of %.2f", limits.MaxHourlySpend), limits.MaxHourlySpend, hourlyCost})
+	}
+	return budgets
+}
+
+func groupBudgets(group string, limits Limits) []budget {
+	budgets := []budget{}
+	if limits.MaxPods > 0 {
+		budgets = append(budgets, budget{fmt.Sprintf("the budget group %s of %d pods", group, limits.MaxPods), float64(limits.MaxPods), podCount})
+	}
+	if limits.MaxHourlySpend > 0 {
+		budgets = append(budgets, budget{fmt.Sprintf("the hourly spend cap of the budget group %s of %.2f", group, limits.MaxHourlySpend), limits.MaxHourlySpend, hourlyCost})
+	}
+	return budgets
+}
+
+// waitingFor is whether a member whose replicas count against the budget
+// didn't report them yet and still may within the ReportTimeout.
+func (c *Coordinator) waitingFor(members []*Member, b budget, now time.Time) bool {
+	for _, m := range members {
+		if !m.reported && b.cost(m) > 0 && now.Before(m.joined.Add(c.ReportTimeout)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Coordinator) now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock.Now()
+}
+
+// epsilon absorbs rounding errors of summed costs.
+const epsilon = 1e-9
+
+// free returns how many replicas m can have with what the other members
+// have, ok is false when m's replicas are free.
+func (b budget) free(m *Member, members []*Member) (int32, bool) {
+	cost := b.cost(m)
+	if cost <= 0 {
+		return 0, false
+	}
+	used := 0.0
+	for _, other := range members {
+		if other != m {
+			used += float64(other.replicas) * b.cost(other)
+		}
+	}
+	return int32(math.Floor((b.limit-used)/cost + epsilon)), true
+}
+
+// allocate lowers wants to fit the budget by handing out replicas one at a
+// time to the member that got the least so far relative to what it wants
+// times its priority. Nobody gets more than it wants.
+func allocate(members []*Member, wants map[*Member]int32, b budget) {
+	got := map[*Member]int32{}
+	left := b.limit
+	for {
+		var next *Member
+		for _, m := range members {
+			if got[m] >= wants[m] || b.cost(m) > left+epsilon {
+				continue
+			}
+			if next == nil || share(m, got[m]) < share(next, got[next]) {
+				next = m
+			}
+		}
+		if next == nil {
+			break
+		}
+		got[next]++
+		left -= b.cost(next)
+	}
+	for _, m := range members {
+		wants[m] = got[m]
+	}
+}
+
+// share is the weighted share m would have with one more replica.
+func share(m *Member, replicas int32) float64 {
+	return float64(replicas+1) / float64(m.priority*int(m.want))
+}
+
+func (c *Coordinator) sortedMembers() []*Member {
+	members := make([]*Member, 0, len(c.members))
+	for _, m := range c.members {
+		members = append(members, m)
+	}
+	// ties go to the same member on every allocation
+	sort.Slice(members, func(i, j int) bool { return members[i].name < members[j].name })
+	return members
+}
+
+func (c *Coordinator) groupMembers(group string) []*Member {
+	members := []*Member{}
+	for _, m := range c.sortedMembers() {
+		if m.group == group {
+			members = append(members, m)
+		}
+	}
+	return members
+}
@@ -0,0 +1,194 @@
+package budget
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestAllocateWithinBudget(t *testing.T) {
+	c := NewCoordinator()
+	c.Total.MaxPods = 10
+	a := c.Join("a", "", 1, 0)
+	b := c.Join("b", "", 1, 0)
+
+	a.Allocate(0, 4)
+	granted, detail := b.Allocate(0, 6)
+	assert.Equal(t, int32(6), granted)
+	assert.Equal(t, "", detail)
+}
+
+func TestAllocateProportionally(t *testing.T) {
+	c := NewCoordinator()
+	c.Total.MaxPods = 10
+	a := c.Join("a", "", 1, 0)
+	b := c.Join("b", "", 1, 0)
+
+	a.Allocate(0, 10)
+	granted, detail := b.Allocate(0, 30)
+	// a wants a quarter of the replicas, b three quarters
+	assert.Equal(t, int32(8), granted)
+	assert.Equal(t, "30 capped to 8 replicas by maxTotalPods of 10 pods", detail)
+	granted, _ = a.Allocate(0, 10)
+	assert.Equal(t, int32(2), granted)
+}
+
+func TestAllocateByPriority(t *testing.T) {
+	c := NewCoordinator()
+	c.Total.MaxPods = 12
+	critical := c.Join("critical", "", 3, 0)
+	batch := c.Join("batch", "", 1, 0)
+
+	batch.Allocate(0, 10)
+	granted, _ := critical.Allocate(0, 10)
+	assert.Equal(t, int32(9), granted)
+	granted, _ = batch.Allocate(0, 10)
+	assert.Equal(t, int32(3), granted)
+
+	// nobody gets more than it wants
+	granted, _ = critical.Allocate(0, 2)
+	assert.Equal(t, int32(2), granted)
+	granted, _ = batch.Allocate(0, 10)
+	assert.Equal(t, int32(10), granted)
+}
+
+func TestAllocateGroups(t *testing.T) {
+	c := NewCoordinator()
+	c.Total.MaxPods = 20
+	c.Groups["payments"] = Limits{MaxPods: 6}
+	a := c.Join("a", "payments", 1, 0)
+	b := c.Join("b", "payments", 1, 0)
+	other := c.Join("other", "", 1, 0)
+	other.Allocate(0, 0)
+
+	a.Allocate(0, 6)
+	granted, detail := b.Allocate(0, 6)
+	assert.Equal(t, int32(3), granted)
+	assert.Equal(t, "6 capped to 3 replicas by the budget group payments of 6 pods", detail)
+
+	// the group leaves the rest of the total budget to others
+	granted, detail = other.Allocate(0, 20)
+	assert.Equal(t, int32(14), granted)
+	assert.Equal(t, "20 capped to 14 replicas by maxTotalPods of 20 pods", detail)
+}
+
+func TestAllocateWaitsForOthersToScaleDown(t *testing.T) {
+	c := NewCoordinator()
+	c.Total.MaxPods = 10
+	a := c.Join("a", "", 1, 0)
+	b := c.Join("b", "", 1, 0)
+
+	a.Allocate(0, 10)
+	a.Scaled(10)
+
+	// b's share is 5, but a still has all 10 replicas
+	granted, _ := b.Allocate(0, 10)
+	assert.Equal(t, int32(0), granted)
+	granted, _ = a.Allocate(10, 10)
+	assert.Equal(t, int32(5), granted)
+	a.Scaled(5)
+	granted, _ = b.Allocate(0, 10)
+	assert.Equal(t, int32(5), granted)
+
+	// replicas above the share aren't taken away by Allocate
+	granted, _ = b.Allocate(7, 10)
+	assert.Equal(t, int32(5), granted)
+}
+
+func TestAllocateWaitsForOthersToReport(t *testing.T) {
+	c := NewCoordinator()
+	c.Total.MaxPods = 10
+	a := c.Join("a", "", 1, 0)
+	b := c.Join("b", "", 1, 0)
+
+	// after a restart b's 5 replicas are unknown until it polled
+	granted, detail := a.Allocate(5, 10)
+	assert.Equal(t, int32(5), granted)
+	assert.Equal(t, "10 capped to 5 replicas by maxTotalPods of 10 pods until every deployment sharing it reported its replicas", detail)
+	granted, _ = b.Allocate(5, 10)
+	assert.Equal(t, int32(5), granted)
+	granted, _ = a.Allocate(5, 10)
+	assert.Equal(t, int32(5), granted)
+}
+
+func TestAllocateStopsWaitingForOthersToReport(t *testing.T) {
+	clk := clock.NewFakeClock(time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC))
+	c := NewCoordinator()
+	c.Clock = clk
+	c.Total.MaxPods = 10
+	a := c.Join("a", "", 1, 0)
+	b := c.Join("b", "", 1, 0)
+	// the deployment of this one can't be read
+	c.Join("unreadable", "", 1, 0)
+
+	// b reported without scaling, e.g. while in its cooldown
+	b.Report(5)
+	granted, _ := a.Allocate(2, 10)
+	assert.Equal(t, int32(2), granted)
+
+	clk.Step(DefaultReportTimeout)
+	granted, detail := a.Allocate(2, 10)
+	assert.Equal(t, int32(5), granted)
+	assert.Equal(t, "10 capped to 5 replicas by maxTotalPods of 10 pods", detail)
+}
+
+func TestLeave(t *testing.T) {
+	c := NewCoordinator()
+	c.Total.MaxPods = 10
+	a := c.Join("a", "", 1, 0)
+	b := c.Join("b", "", 1, 0)
+
+	a.Allocate(0, 10)
+	a.Leave()
+	granted, _ := b.Allocate(0, 10)
+	assert.Equal(t, int32(10), granted)
+}
+
+func TestNilMemberIsUnlimited(t *testing.T) {
+	var m *Member
+	granted, detail := m.Allocate(3, 100)
+	assert.Equal(t, int32(100), granted)
+	assert.Equal(t, "", detail)
+	assert.NotPanics(t, func() {
+		m.Scaled(100)
+		m.Leave()
+	})
+}
+
+func TestAllocateHourlySpend(t *testing.T) {
+	c := NewCoordinator()
+	c.Total.MaxHourlySpend = 10
+	cheap := c.Join("cheap", "", 1, 0.5)
+	expensive := c.Join("expensive", "", 1, 2)
+
+	cheap.Allocate(0, 10)
+	granted, detail := expensive.Allocate(0, 10)
+	// shares are by replicas, so both get the same number of them
+	assert.Equal(t, int32(4), granted)
+	assert.Equal(t, "10 capped to 4 replicas by maxHourlySpend of 10.00", detail)
+	granted, _ = cheap.Allocate(0, 10)
+	assert.Equal(t, int32(4), granted)
+	spend, ok := expensive.HourlySpend(4)
+	assert.True(t, ok)
+	assert.Equal(t, 8.0, spend)
+	_, ok = c.Join("free", "", 1, 0).HourlySpend(4)
+	assert.False(t, ok)
+}
+
+func TestAllocateGroupHourlySpend(t *testing.T) {
+	c := NewCoordinator()
+	c.Groups["consumers"] = Limits{MaxHourlySpend: 3}
+	m := c.Join("consumer", "consumers", 1, 0.25)
+	free := c.Join("free", "consumers", 1, 0)
+
+	granted, detail := m.Allocate(0, 100)
+	assert.Equal(t, int32(12), granted)
+	assert.Equal(t, "100 capped to 12 replicas by the hourly spend cap of the budget group consumers of 3.00", detail)
+
+	// pods without a cost aren't limited by spend caps
+	granted, _ = free.Allocate(0, 100)
+	assert.Equal(t, int32(100), granted)
+}
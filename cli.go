@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"kube-sqs-autoscaler/config"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	cmdRun         = "run"
+	cmdValidate    = "validate"
+	cmdPrintConfig = "print-config"
+)
+
+// EffectiveConfig is the fully resolved configuration the autoscaler would
+// run with, including flag and config defaults. Secrets such as the admin
+// token are left out, only the files holding them are shown.
+type EffectiveConfig struct {
+	KubernetesNamespace string                  `json:"kubernetesNamespace"`
+	AwsRegion           string                  `json:"awsRegion"`
+	AwsEndpoint         string                  `json:"awsEndpoint,omitempty"`
+	AwsStsEndpoint      string                  `json:"awsStsEndpoint,omitempty"`
+	DryRun              bool                    `json:"dryRun"`
+	ListenAddress       string                  `json:"listenAddress"`
+	ReadinessIntervals  int                     `json:"readinessIntervals"`
+	LivenessIntervals   int                     `json:"livenessIntervals"`
+	DecisionHistory     int                     `json:"decisionHistory"`
+	AdminTokenFile      string                  `json:"adminTokenFile,omitempty"`
+	LeaderElect         bool                    `json:"leaderElect"`
+	LeaderElection      *LeaderElectionConfig   `json:"leaderElection,omitempty"`
+	StatusAnnotations   bool                    `json:"statusAnnotations"`
+	PersistCoolDowns    bool                    `json:"persistCoolDowns"`
+	LogFormat           string                  `json:"logFormat"`
+	LogLevel            string                  `json:"logLevel"`
+	ConflictWindow      config.Duration         `json:"conflictWindow"`
+	Retry               RetryConfig             `json:"retry"`
+	MaxTotalPods        int                     `json:"maxTotalPods,omitempty"`
+	MaxHourlySpend      float64                 `json:"maxHourlySpend,omitempty"`
+	FreezeWindows       config.FreezeWindowFlag `json:"freezeWindows,omitempty"`
+	BudgetGroups        config.BudgetGroupFlag  `json:"budgetGroups,omitempty"`
+	Scalers             config.ScalerConfigs    `json:"scalers"`
+}
+
+// LeaderElectionConfig is the lease of the EffectiveConfig with leader
+// election.
+type LeaderElectionConfig struct {
+	LeaseName      string          `json:"leaseName"`
+	LeaseNamespace string          `json:"leaseNamespace"`
+	LeaseDuration  config.Duration `json:"leaseDuration"`
+	RenewDeadline  config.Duration `json:"renewDeadline"`
+	RetryPeriod    config.Duration `json:"retryPeriod"`
+}
+
+// RetryConfig is the retry policy of the EffectiveConfig.
+type RetryConfig struct {
+	MaxAttempts int             `json:"maxAttempts"`
+	BaseDelay   config.Duration `json:"baseDelay"`
+	MaxDelay    config.Duration `json:"maxDelay"`
+	Jitter      float64         `json:"jitter"`
+}
+
+// splitCommand returns the subcommand and the remaining flags. Running
+// without a subcommand keeps the old behaviour of starting the autoscaler.
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return cmdRun, args
+	}
+	return args[0], args[1:]
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [run|validate|print-config] [flags]\n\n", os.Args[0])
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  run           start the autoscaler (default)")
+	fmt.Fprintln(out, "  validate      check the given configs and exit non-zero if they are invalid")
+	fmt.Fprintln(out, "  print-config  print the effective configuration including defaults")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func validate(out io.Writer, configs, configFiles config.ConfigFlag) int {
+	parsedConfigs, err := config.Load(configs, configFiles, globals())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config is invalid:\n%s\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "config is valid, %d scaler(s) configured\n", len(parsedConfigs))
+	return 0
+}
+
+func printConfig(out io.Writer, configs, configFiles config.ConfigFlag, format string) int {
+	parsedConfigs, err := config.Load(configs, configFiles, globals())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config is invalid:\n%s\n", err)
+		return 1
+	}
+
+	b, err := marshalConfig(effectiveConfig(parsedConfigs), format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	out.Write(b)
+	return 0
+}
+
+func globals() config.Globals {
+	return config.Globals{
+		Namespace:   kubernetesNamespace,
+		Region:      awsRegion,
+		Endpoint:    awsEndpoint,
+		StsEndpoint: awsStsEndpoint,
+
+		BudgetGroups:   budgetGroups,
+		MaxHourlySpend: maxHourlySpend,
+	}
+}
+
+func effectiveConfig(c config.ScalerConfigs) *EffectiveConfig {
+	effective := &EffectiveConfig{
+		KubernetesNamespace: kubernetesNamespace,
+		AwsRegion:           awsRegion,
+		AwsEndpoint:         awsEndpoint,
+		AwsStsEndpoint:      awsStsEndpoint,
+		DryRun:              dryRun,
+		ListenAddress:       listenAddress,
+		ReadinessIntervals:  readinessIntervals,
+		LivenessIntervals:   livenessIntervals,
+		DecisionHistory:     decisionHistory,
+		AdminTokenFile:      adminTokenFile,
+		LeaderElect:         leaderElect,
+		StatusAnnotations:   statusAnnotations,
+		PersistCoolDowns:    persistCoolDowns,
+		LogFormat:           logFormat,
+		LogLevel:            logLevel,
+		ConflictWindow:      config.Duration(conflictWindow),
+		MaxTotalPods:        maxTotalPods,
+		MaxHourlySpend:      maxHourlySpend,
+		FreezeWindows:       freezeWindows,
+		BudgetGroups:        budgetGroups,
+		Scalers:             c,
+	}
+	effective.Retry = RetryConfig{
+		MaxAttempts: retryPolicy.MaxAttempts,
+		BaseDelay:   config.Duration(retryPolicy.BaseDelay),
+		MaxDelay:    config.Duration(retryPolicy.MaxDelay),
+		Jitter:      retryPolicy.Jitter,
+	}
+	if leaderElect {
+		effective.LeaderElection = &LeaderElectionConfig{
+			LeaseName:      leaderElection.LeaseName,
+			LeaseNamespace: leaderElection.leaseNamespace(),
+			LeaseDuration:  config.Duration(leaderElection.LeaseDuration),
+			RenewDeadline:  config.Duration(leaderElection.RenewDeadline),
+			RetryPeriod:    config.Duration(leaderElection.RetryPeriod),
+		}
+	}
+	return effective
+}
+
+func marshalConfig(c *EffectiveConfig, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	case "yaml":
+		return yaml.Marshal(c)
+	default:
+		return nil, fmt.Errorf("unknown output format %q, use json or yaml", format)
+	}
+}
+
+// configureLogging sets the format, text or json, and the level of logger.
+// validateFlags checks the flags that can be parsed but make no sense.
+func validateFlags() error {
+	if decisionHistory < 0 {
+		return fmt.Errorf("--decision-history must not be negative, got %d", decisionHistory)
+	}
+	if readinessIntervals < 1 {
+		return fmt.Errorf("--readiness-intervals must be at least 1, got %d", readinessIntervals)
+	}
+	if livenessIntervals < 1 {
+		return fmt.Errorf("--liveness-intervals must be at least 1, got %d", livenessIntervals)
+	}
+	return nil
+}
+
+func configureLogging(logger *log.Logger, format, level string) error {
+	switch format {
+	case "text":
+		logger.SetFormatter(&log.TextFormatter{})
+	case "json":
+		logger.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+
+	l, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(l)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"kube-sqs-autoscaler/config"
+	"kube-sqs-autoscaler/retry"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommand(t *testing.T) {
+	cmd, args := splitCommand([]string{"--dry-run=false"})
+	assert.Equal(t, cmdRun, cmd)
+	assert.Equal(t, []string{"--dry-run=false"}, args)
+
+	cmd, args = splitCommand([]string{"validate", "--config={}"})
+	assert.Equal(t, cmdValidate, cmd)
+	assert.Equal(t, []string{"--config={}"}, args)
+}
+
+func TestValidate(t *testing.T) {
+	out := &bytes.Buffer{}
+	valid := config.ConfigFlag{`{"messagePerPod": 10, "maxPods": 5, "queueName": "queue", "deploymentName": "deploy"}`}
+	assert.Equal(t, 0, validate(out, valid, nil))
+
+	invalid := config.ConfigFlag{`{"messagePerPod": 10, "maxPods": 5, "deploymentName": "deploy"}`}
+	assert.Equal(t, 1, validate(out, invalid, nil))
+}
+
+func TestPrintConfigIncludesDefaults(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	awsRegion = "us-east-1"
+	dryRun = true
+	listenAddress = ":8080"
+	readinessIntervals = 3
+	decisionHistory = 50
+	adminTokenFile = "/etc/admin/token"
+	statusAnnotations = true
+	persistCoolDowns = true
+	logLevel = "debug"
+	conflictWindow = 10 * time.Minute
+	retryPolicy = retry.Policy{MaxAttempts: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 2 * time.Second, Jitter: 0.2}
+
+	out := &bytes.Buffer{}
+	c := config.ConfigFlag{`{"messagePerPod": 10, "maxPods": 5, "queueName": "queue", "deploymentName": "deploy"}`}
+	assert.Equal(t, 0, printConfig(out, c, nil, "json"))
+
+	var printed map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &printed))
+	assert.Equal(t, "namespace", printed["kubernetesNamespace"])
+	assert.Equal(t, true, printed["dryRun"])
+	assert.Equal(t, ":8080", printed["listenAddress"])
+	assert.Equal(t, 3.0, printed["readinessIntervals"])
+	assert.Equal(t, 50.0, printed["decisionHistory"])
+	assert.Equal(t, "/etc/admin/token", printed["adminTokenFile"])
+	assert.Equal(t, true, printed["statusAnnotations"])
+	assert.Equal(t, true, printed["persistCoolDowns"])
+	assert.Equal(t, "debug", printed["logLevel"])
+	assert.Equal(t, "10m0s", printed["conflictWindow"])
+	assert.Equal(t, map[string]interface{}{"maxAttempts": 3.0, "baseDelay": "200ms", "maxDelay": "2s", "jitter": 0.2}, printed["retry"])
+	scaler := printed["scalers"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "5s", scaler["pollInterval"])
+
+	out.Reset()
+	assert.Equal(t, 0, printConfig(out, c, nil, "yaml"))
+	assert.Contains(t, out.String(), "pollInterval: 5s")
+
+	assert.Equal(t, 1, printConfig(out, c, nil, "xml"))
+}
+
+func TestConfigureLogging(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := log.New()
+	logger.SetOutput(out)
+
+	assert.Nil(t, configureLogging(logger, "json", "warn"))
+	logger.WithField("deployment", "deploy").Info("hidden")
+	logger.WithField("deployment", "deploy").Warn("shown")
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &line))
+	assert.Equal(t, "shown", line["msg"])
+	assert.Equal(t, "deploy", line["deployment"])
+
+	assert.EqualError(t, configureLogging(logger, "xml", "info"), `unknown log format "xml", use text or json`)
+	assert.EqualError(t, configureLogging(logger, "text", "loud"), `not a valid logrus Level: "loud"`)
+}
+
+func TestPrintConfigIncludesLeaderElection(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	leaderElect = true
+	leaderElection = leaderElectionConfig{LeaseName: "lease", LeaseDuration: 15 * time.Second, RenewDeadline: 10 * time.Second, RetryPeriod: 2 * time.Second}
+	defer func() { leaderElect, leaderElection = false, leaderElectionConfig{} }()
+
+	out := &bytes.Buffer{}
+	c := config.ConfigFlag{`{"messagePerPod": 10, "maxPods": 5, "queueName": "queue", "deploymentName": "deploy"}`}
+	assert.Equal(t, 0, printConfig(out, c, nil, "yaml"))
+	assert.Contains(t, out.String(), "leaderElect: true\n")
+	assert.Contains(t, out.String(), "leaderElection:\n  leaseDuration: 15s\n  leaseName: lease\n  leaseNamespace: namespace\n  renewDeadline: 10s\n  retryPeriod: 2s\n")
+}
+
+func TestValidateFlags(t *testing.T) {
+	defer func() { decisionHistory, readinessIntervals, livenessIntervals = 0, 0, 0 }()
+
+	decisionHistory, readinessIntervals, livenessIntervals = 50, 3, 10
+	assert.Nil(t, validateFlags())
+	decisionHistory = -1
+	assert.EqualError(t, validateFlags(), "--decision-history must not be negative, got -1")
+	decisionHistory = 50
+	readinessIntervals = 0
+	assert.EqualError(t, validateFlags(), "--readiness-intervals must be at least 1, got 0")
+	readinessIntervals = 3
+	livenessIntervals = -2
+	assert.EqualError(t, validateFlags(), "--liveness-intervals must be at least 1, got -2")
+}
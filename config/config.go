@@ -3,7 +3,32 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/robfig/cron/v3"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultPollInterval = Duration(5 * time.Second)
+	// DefaultMetricFailureAfter is how many reads in a row have to fail
+	// before the onMetricFailure policy is applied.
+	DefaultMetricFailureAfter = 3
+	// DefaultPriority is the priority of a deployment when budgets are
+	// shared.
+	DefaultPriority = 1
+)
+
+// Policies of onMetricFailure.
+const (
+	MetricFailureHold     = "hold"
+	MetricFailureFallback = "fallback"
+	MetricFailureMax      = "max"
 )
 
 type ConfigFlag []string
@@ -17,6 +42,147 @@ func (i *ConfigFlag) Set(value string) error {
 	return nil
 }
 
+// BudgetGroup limits the total replicas of the deployments in it, or what
+// they cost per hour.
+type BudgetGroup struct {
+	Name           string  `json:"name"`
+	MaxPods        int     `json:"maxPods,omitempty"`
+	MaxHourlySpend float64 `json:"maxHourlySpend,omitempty"`
+}
+
+// BudgetGroupFlag collects budget groups given as
+// name=<name>,maxPods=<n>,maxHourlySpend=<x>.
+type BudgetGroupFlag []BudgetGroup
+
+func (b *BudgetGroupFlag) String() string {
+	return ""
+}
+
+func (b *BudgetGroupFlag) Set(value string) error {
+	g := BudgetGroup{}
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid budget group field %q, use key=value", field)
+		}
+		switch kv[0] {
+		case "name":
+			g.Name = kv[1]
+		case "maxPods":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("maxPods of budget group must be a positive number, got %q", kv[1])
+			}
+			g.MaxPods = n
+		case "maxHourlySpend":
+			x, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil || x <= 0 {
+				return fmt.Errorf("maxHourlySpend of budget group must be a positive number, got %q", kv[1])
+			}
+			g.MaxHourlySpend = x
+		default:
+			return fmt.Errorf("unknown budget group field %q", kv[0])
+		}
+	}
+	if g.Name == "" || (g.MaxPods == 0 && g.MaxHourlySpend == 0) {
+		return errors.New("budget groups need a name and maxPods or maxHourlySpend")
+	}
+	*b = append(*b, g)
+	return nil
+}
+
+// Modes of freeze windows.
+const (
+	FreezeNoScaleDown = "noScaleDown"
+	FreezeNoScaling   = "noScaling"
+)
+
+// FreezeWindow is a period the deployment isn't scaled down, or not scaled at
+// all, in depending on Mode. It either recurs on a cron Schedule, in UTC
+// unless the schedule starts with CRON_TZ=<zone>, and lasts Duration, or runs
+// from Start to End once.
+type FreezeWindow struct {
+	Name     string     `json:"name,omitempty"`
+	Mode     string     `json:"mode"`
+	Schedule string     `json:"schedule,omitempty"`
+	Duration Duration   `json:"duration,omitempty"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+}
+
+// Active tells if the window is open at now and when it closes.
+func (w *FreezeWindow) Active(now time.Time) (bool, time.Time) {
+	if w.Schedule == "" {
+		if w.Start == nil || w.End == nil {
+			return false, time.Time{}
+		}
+		return !now.Before(*w.Start) && now.Before(*w.End), *w.End
+	}
+	schedule, err := cron.ParseStandard(w.Schedule)
+	if err != nil {
+		return false, time.Time{}
+	}
+	// the window is open when it started within the last Duration
+	start := schedule.Next(now.Add(-w.Duration.ToDuration()).UTC())
+	return !start.After(now), start.Add(w.Duration.ToDuration())
+}
+
+// String names the window in logs and decisions.
+func (w *FreezeWindow) String() string {
+	switch {
+	case w.Name != "":
+		return w.Name
+	case w.Schedule != "":
+		return fmt.Sprintf("%q for %s", w.Schedule, w.Duration.ToDuration())
+	case w.Start != nil && w.End != nil:
+		return fmt.Sprintf("%s to %s", w.Start.UTC().Format(time.RFC3339), w.End.UTC().Format(time.RFC3339))
+	}
+	return "<invalid>"
+}
+
+// problems lists what's wrong with the window.
+func (w *FreezeWindow) problems() []string {
+	problems := []string{}
+	if w.Mode != FreezeNoScaleDown && w.Mode != FreezeNoScaling {
+		problems = append(problems, "mode must be noScaleDown or noScaling")
+	}
+	switch {
+	case w.Schedule != "" && (w.Start != nil || w.End != nil):
+		problems = append(problems, "schedule can't be used with start and end")
+	case w.Schedule != "":
+		if _, err := cron.ParseStandard(w.Schedule); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid schedule: %v", err))
+		}
+		if w.Duration <= 0 {
+			problems = append(problems, "a schedule needs a positive duration")
+		}
+	case w.Start == nil || w.End == nil:
+		problems = append(problems, "either schedule and duration or start and end are required")
+	case !w.End.After(*w.Start):
+		problems = append(problems, "end must be after start")
+	}
+	return problems
+}
+
+// FreezeWindowFlag collects freeze windows for all deployments given as JSON.
+type FreezeWindowFlag []FreezeWindow
+
+func (f *FreezeWindowFlag) String() string {
+	return ""
+}
+
+func (f *FreezeWindowFlag) Set(value string) error {
+	var w FreezeWindow
+	if err := json.Unmarshal([]byte(value), &w); err != nil {
+		return err
+	}
+	if problems := w.problems(); len(problems) > 0 {
+		return fmt.Errorf("invalid freeze window: %s", strings.Join(problems, ", "))
+	}
+	*f = append(*f, w)
+	return nil
+}
+
 // https://stackoverflow.com/a/54571600
 type Duration time.Duration
 
@@ -51,43 +217,378 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 }
 
 type ScalerConfig struct {
-	PollInterval             Duration `json:"pollInterval"`
-	CoolDownPeriod           Duration `json:"coolDownPeriod"`
-	MessagePerPod            int      `json:"messagePerPod"`
-	MaxPods                  int      `json:"maxPods"`
-	ZeroScaling              bool     `json:"zeroScaling"`
-	ZeroScalingCoolDown      Duration `json:"zeroScalingCoolDown"`
-	QueueName                string   `json:"queueName"`
-	KubernetesDeploymentName string   `json:"deploymentName"`
+	PollInterval             Duration       `json:"pollInterval"`
+	CoolDownPeriod           Duration       `json:"coolDownPeriod"`
+	MessagePerPod            int            `json:"messagePerPod"`
+	MaxPods                  int            `json:"maxPods"`
+	ZeroScaling              bool           `json:"zeroScaling"`
+	ZeroScalingCoolDown      Duration       `json:"zeroScalingCoolDown"`
+	QueueName                string         `json:"queueName"`
+	QueueUrl                 string         `json:"queueUrl,omitempty"`
+	QueueArn                 string         `json:"queueArn,omitempty"`
+	QueueOwnerAccountId      string         `json:"queueOwnerAwsAccountId,omitempty"`
+	KubernetesDeploymentName string         `json:"deploymentName"`
+	Namespace                string         `json:"namespace,omitempty"`
+	Region                   string         `json:"region,omitempty"`
+	Endpoint                 string         `json:"endpoint,omitempty"`
+	RoleArn                  string         `json:"roleArn,omitempty"`
+	ExternalId               string         `json:"externalId,omitempty"`
+	WebIdentityTokenFile     string         `json:"webIdentityTokenFile,omitempty"`
+	StsEndpoint              string         `json:"stsEndpoint,omitempty"`
+	IgnoreConflicts          bool           `json:"ignoreConflicts,omitempty"`
+	ManualOverrideHold       Duration       `json:"manualOverrideHold,omitempty"`
+	OnMetricFailure          *MetricFailure `json:"onMetricFailure,omitempty"`
+	ReadinessAware           bool           `json:"readinessAware,omitempty"`
+	CapacityAware            bool           `json:"capacityAware,omitempty"`
+	CapacityHeadroom         int            `json:"capacityHeadroom,omitempty"`
+	BudgetGroup              string         `json:"budgetGroup,omitempty"`
+	Priority                 int            `json:"priority,omitempty"`
+	PodHourlyCost            float64        `json:"podHourlyCost,omitempty"`
+	FreezeWindows            []FreezeWindow `json:"freezeWindows,omitempty"`
+}
+
+// MetricFailure is what a scaler does once the backlog couldn't be read After
+// times in a row, or not for StaleAfter: hold the replicas, scale to Replicas
+// with the fallback policy or scale to maxPods.
+type MetricFailure struct {
+	Policy     string   `json:"policy"`
+	Replicas   int      `json:"replicas,omitempty"`
+	After      int      `json:"after,omitempty"`
+	StaleAfter Duration `json:"staleAfter,omitempty"`
 }
 
 type ScalerConfigs []*ScalerConfig
 
+// Globals are the process wide settings given as flags, scaler configs can
+// override them per entry.
+type Globals struct {
+	Namespace   string
+	Region      string
+	Endpoint    string
+	StsEndpoint string
+	// BudgetGroups are the groups configs can be assigned to. With a spend
+	// cap, total or of their group, configs need a podHourlyCost.
+	BudgetGroups   BudgetGroupFlag
+	MaxHourlySpend float64
+}
+
+// ApplyDefaults fills in the optional fields that were left empty.
+func (s *ScalerConfig) ApplyDefaults() {
+	if s.PollInterval == 0 {
+		s.PollInterval = DefaultPollInterval
+	}
+	if s.Priority == 0 {
+		s.Priority = DefaultPriority
+	}
+	if s.OnMetricFailure != nil && s.OnMetricFailure.After == 0 {
+		s.OnMetricFailure.After = DefaultMetricFailureAfter
+	}
+}
+
+// ApplyGlobals uses the process wide settings for the fields a config
+// doesn't override.
+func (s *ScalerConfig) ApplyGlobals(g Globals) {
+	if s.Namespace == "" {
+		s.Namespace = g.Namespace
+	}
+	if s.Region == "" && s.QueueArn != "" {
+		// a queue given by arn lives in the arn's region
+		if a, err := arn.Parse(s.QueueArn); err == nil {
+			s.Region = a.Region
+		}
+	}
+	if s.Region == "" {
+		s.Region = g.Region
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = g.Endpoint
+	}
+	if s.StsEndpoint == "" {
+		s.StsEndpoint = g.StsEndpoint
+	}
+}
+
+// Target names the scaled deployment, including its namespace when known.
+func (s *ScalerConfig) Target() string {
+	if s.Namespace == "" {
+		return s.KubernetesDeploymentName
+	}
+	return s.Namespace + "/" + s.KubernetesDeploymentName
+}
+
+// QueueIdentifier returns whichever of the queue name, url or arn is set.
+func (s *ScalerConfig) QueueIdentifier() string {
+	switch {
+	case s.QueueName != "":
+		return s.QueueName
+	case s.QueueUrl != "":
+		return s.QueueUrl
+	default:
+		return s.QueueArn
+	}
+}
+
+// Validate returns an error listing every problem found in the config.
+func (s *ScalerConfig) Validate() error {
+	problems := []string{}
+	if s.KubernetesDeploymentName == "" {
+		problems = append(problems, "deploymentName is required")
+	}
+	switch queues := countNonEmpty(s.QueueName, s.QueueUrl, s.QueueArn); {
+	case queues == 0:
+		problems = append(problems, "one of queueName, queueUrl or queueArn is required")
+	case queues > 1:
+		problems = append(problems, "only one of queueName, queueUrl or queueArn can be given")
+	}
+	if s.QueueArn != "" {
+		if a, err := arn.Parse(s.QueueArn); err != nil || a.Service != "sqs" {
+			problems = append(problems, "queueArn is not a valid sqs arn")
+		}
+	}
+	if s.QueueUrl != "" && s.QueueOwnerAccountId != "" {
+		problems = append(problems, "queueOwnerAwsAccountId can't be used with queueUrl")
+	}
+	if s.MessagePerPod <= 0 {
+		problems = append(problems, "messagePerPod must be greater than 0")
+	}
+	if s.MaxPods <= 1 {
+		problems = append(problems, "maxPods must be greater than 1")
+	}
+	if s.RoleArn == "" && (s.ExternalId != "" || s.WebIdentityTokenFile != "") {
+		problems = append(problems, "externalId and webIdentityTokenFile need a roleArn")
+	}
+	if s.ExternalId != "" && s.WebIdentityTokenFile != "" {
+		problems = append(problems, "externalId can't be used with webIdentityTokenFile")
+	}
+	if s.PollInterval < 0 {
+		problems = append(problems, "pollInterval must not be negative")
+	}
+	if s.CoolDownPeriod < 0 {
+		problems = append(problems, "coolDownPeriod must not be negative")
+	}
+	if s.ZeroScalingCoolDown < 0 {
+		problems = append(problems, "zeroScalingCoolDown must not be negative")
+	}
+	if s.ManualOverrideHold < 0 {
+		problems = append(problems, "manualOverrideHold must not be negative")
+	}
+	if s.Priority < 0 {
+		problems = append(problems, "priority must not be negative")
+	}
+	if s.PodHourlyCost < 0 {
+		problems = append(problems, "podHourlyCost must not be negative")
+	}
+	if s.CapacityHeadroom < 0 {
+		problems = append(problems, "capacityHeadroom must not be negative")
+	}
+	for i := range s.FreezeWindows {
+		for _, problem := range s.FreezeWindows[i].problems() {
+			problems = append(problems, fmt.Sprintf("freezeWindows[%d]: %s", i, problem))
+		}
+	}
+	if f := s.OnMetricFailure; f != nil {
+		switch f.Policy {
+		case MetricFailureHold, MetricFailureMax:
+			if f.Replicas != 0 {
+				problems = append(problems, "onMetricFailure.replicas can only be used with the fallback policy")
+			}
+		case MetricFailureFallback:
+			if f.Replicas < 0 || f.Replicas > s.MaxPods {
+				problems = append(problems, "onMetricFailure.replicas must be between 0 and maxPods")
+			}
+		default:
+			problems = append(problems, "onMetricFailure.policy must be one of hold, fallback or max")
+		}
+		if f.After < 0 {
+			problems = append(problems, "onMetricFailure.after must not be negative")
+		}
+		if f.StaleAfter < 0 {
+			problems = append(problems, "onMetricFailure.staleAfter must not be negative")
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	name := s.KubernetesDeploymentName
+	if name == "" {
+		name = "<unnamed>"
+	}
+	return fmt.Errorf("invalid config for deployment %s: %s", name, strings.Join(problems, ", "))
+}
+
+func (c ScalerConfigs) ApplyGlobals(g Globals) {
+	for _, sc := range c {
+		sc.ApplyGlobals(g)
+	}
+}
+
+func countNonEmpty(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Validate checks every config and also makes sure no deployment is scaled by
+// more than one config.
+func (c ScalerConfigs) Validate() error {
+	problems := []string{}
+	seen := map[string]bool{}
+	for _, sc := range c {
+		if err := sc.Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if sc.KubernetesDeploymentName == "" {
+			continue
+		}
+		// the same deployment name in different namespaces is a different target
+		key := sc.Namespace + "/" + sc.KubernetesDeploymentName
+		if seen[key] {
+			problems = append(problems, fmt.Sprintf("deployment %s is configured more than once", sc.KubernetesDeploymentName))
+		}
+		seen[key] = true
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+// ParseConfigFlags parses and validates the configs given as JSON. The valid
+// configs are returned along with an error listing the problems of all the
+// others.
 func ParseConfigFlags(c ConfigFlag) (ScalerConfigs, error) {
 	parsedConfigs := ScalerConfigs{}
-	var err error
+	problems := []string{}
 	for _, conf := range c {
 		var sc ScalerConfig
-		err = json.Unmarshal([]byte(conf), &sc)
-		if err != nil {
-			// bail
-			return parsedConfigs, err
+		if err := json.Unmarshal([]byte(conf), &sc); err != nil {
+			problems = append(problems, err.Error())
+			continue
 		}
 
-		if isConfigValid(sc) == false {
-			return parsedConfigs, errors.New("Some fields are missing")
+		sc.ApplyDefaults()
+		if err := sc.Validate(); err != nil {
+			problems = append(problems, err.Error())
+			continue
 		}
 		parsedConfigs = append(parsedConfigs, &sc)
 	}
+	return parsedConfigs, joinProblems(problems)
+}
+
+// ParseConfigFiles reads scaler configs from JSON or YAML files. A file can
+// either hold a single config or a list of them. Like ParseConfigFlags the
+// valid configs are returned along with the problems of all files.
+func ParseConfigFiles(paths ConfigFlag) (ScalerConfigs, error) {
+	parsedConfigs := ScalerConfigs{}
+	problems := []string{}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		j, err := yaml.YAMLToJSON(b)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+
+		fileConfigs := ScalerConfigs{}
+		if strings.HasPrefix(strings.TrimSpace(string(j)), "[") {
+			err = json.Unmarshal(j, &fileConfigs)
+		} else {
+			var sc ScalerConfig
+			err = json.Unmarshal(j, &sc)
+			fileConfigs = append(fileConfigs, &sc)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+
+		for _, sc := range fileConfigs {
+			sc.ApplyDefaults()
+			if err := sc.Validate(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+				continue
+			}
+			parsedConfigs = append(parsedConfigs, sc)
+		}
+	}
+	return parsedConfigs, joinProblems(problems)
+}
+
+// Load parses configs given with --config and --config-file, fills in the
+// globals and validates them together. The error lists every problem found.
+func Load(flags ConfigFlag, files ConfigFlag, g Globals) (ScalerConfigs, error) {
+	problems := []string{}
+	parsedConfigs, err := ParseConfigFlags(flags)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	fileConfigs, err := ParseConfigFiles(files)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	parsedConfigs = append(parsedConfigs, fileConfigs...)
+
+	if len(parsedConfigs) == 0 && len(problems) == 0 {
+		return nil, errors.New("no scaler configs given, use --config or --config-file")
+	}
+
+	parsedConfigs.ApplyGlobals(g)
+	if err := parsedConfigs.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := parsedConfigs.validateBudgets(g); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := joinProblems(problems); err != nil {
+		return nil, err
+	}
 	return parsedConfigs, nil
 }
 
-func isConfigValid(s ScalerConfig) bool {
-	if s.MessagePerPod > 0 &&
-		s.MaxPods > 1 &&
-		s.QueueName != "" &&
-		s.KubernetesDeploymentName != "" {
-		return true
+func joinProblems(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "\n"))
+}
+
+// validateBudgets makes sure every budget group is defined once, the configs
+// only use defined ones and have a podHourlyCost when their spend is capped.
+func (c ScalerConfigs) validateBudgets(g Globals) error {
+	problems := []string{}
+	defined := map[string]*BudgetGroup{}
+	for i, group := range g.BudgetGroups {
+		if defined[group.Name] != nil {
+			problems = append(problems, fmt.Sprintf("budget group %s is defined more than once", group.Name))
+		}
+		defined[group.Name] = &g.BudgetGroups[i]
+	}
+	for _, sc := range c {
+		group := defined[sc.BudgetGroup]
+		if sc.BudgetGroup != "" && group == nil {
+			problems = append(problems, fmt.Sprintf("budget group %s of deployment %s isn't defined, use --budget-group", sc.BudgetGroup, sc.KubernetesDeploymentName))
+		}
+		spendCapped := g.MaxHourlySpend > 0 || (group != nil && group.MaxHourlySpend > 0)
+		if spendCapped && sc.PodHourlyCost == 0 {
+			problems = append(problems, fmt.Sprintf("deployment %s needs a podHourlyCost, its hourly spend is capped", sc.KubernetesDeploymentName))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
 	}
-	return false
+	return errors.New(strings.Join(problems, "\n"))
 }
@@ -2,6 +2,9 @@ package config
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -99,3 +102,245 @@ func TestParseFailureDataValidation(t *testing.T) {
 	}
 
 }
+
+func TestParseAppliesDefaults(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "some-queue-name", "deploymentName": "deployment-name"}`)
+	cfgs, err := ParseConfigFlags(*f)
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, cfgs[0].PollInterval.ToDuration())
+}
+
+func TestParseConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	yamlFile := filepath.Join(dir, "scalers.yaml")
+	ioutil.WriteFile(yamlFile, []byte(`
+- pollInterval: 10s
+  messagePerPod: 10
+  maxPods: 5
+  queueName: queue-a
+  deploymentName: deployment-a
+- messagePerPod: 20
+  maxPods: 6
+  queueName: queue-b
+  deploymentName: deployment-b
+`), 0644)
+	jsonFile := filepath.Join(dir, "scaler.json")
+	ioutil.WriteFile(jsonFile, []byte(`{"messagePerPod": 30, "maxPods": 7, "queueName": "queue-c", "deploymentName": "deployment-c"}`), 0644)
+
+	cfgs, err := ParseConfigFiles(ConfigFlag{yamlFile, jsonFile})
+	assert.Nil(t, err)
+	assert.Len(t, cfgs, 3)
+	assert.Equal(t, 10*time.Second, cfgs[0].PollInterval.ToDuration())
+	assert.Equal(t, 5*time.Second, cfgs[1].PollInterval.ToDuration())
+	assert.Equal(t, "deployment-c", cfgs[2].KubernetesDeploymentName)
+	assert.Equal(t, 7, cfgs[2].MaxPods)
+}
+
+func TestLoadFailsOnDuplicateDeployments(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-a", "deploymentName": "deployment-name"}`)
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-b", "deploymentName": "deployment-name"}`)
+	_, err := Load(*f, nil, Globals{Namespace: "default"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "configured more than once")
+}
+
+func TestValidateListsAllProblems(t *testing.T) {
+	sc := ScalerConfig{MaxPods: 1}
+	err := sc.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "deploymentName is required")
+	assert.Contains(t, err.Error(), "one of queueName, queueUrl or queueArn is required")
+	assert.Contains(t, err.Error(), "messagePerPod must be greater than 0")
+	assert.Contains(t, err.Error(), "maxPods must be greater than 1")
+}
+
+func TestLoadListsProblemsOfAllConfigs(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 0, "maxPods": 10, "queueName": "queue-a", "deploymentName": "deployment-a"}`)
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-b", "deploymentName": "deployment-b"}`)
+	f.Set(`{"messagePerPod": 100, "maxPods": 1, "queueName": "queue-c", "deploymentName": "deployment-c"}`)
+	f.Set(`{not json}`)
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-d", "deploymentName": "deployment-b"}`)
+
+	_, err := Load(*f, nil, Globals{Namespace: "default"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid config for deployment deployment-a: messagePerPod must be greater than 0")
+	assert.Contains(t, err.Error(), "invalid config for deployment deployment-c: maxPods must be greater than 1")
+	assert.Contains(t, err.Error(), "invalid character")
+	assert.Contains(t, err.Error(), "deployment deployment-b is configured more than once")
+}
+
+func TestLoadAppliesGlobals(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-a", "deploymentName": "deployment-a"}`)
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-b", "deploymentName": "deployment-b", "namespace": "other", "region": "eu-west-1", "endpoint": "localhost:9324"}`)
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-c", "deploymentName": "deployment-a", "namespace": "other"}`)
+	cfgs, err := Load(*f, nil, Globals{Namespace: "default", Region: "us-east-1"})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "default", cfgs[0].Namespace)
+	assert.Equal(t, "us-east-1", cfgs[0].Region)
+	assert.Equal(t, "", cfgs[0].Endpoint)
+
+	assert.Equal(t, "other", cfgs[1].Namespace)
+	assert.Equal(t, "eu-west-1", cfgs[1].Region)
+	assert.Equal(t, "localhost:9324", cfgs[1].Endpoint)
+
+	assert.Equal(t, "other", cfgs[2].Namespace)
+}
+
+func TestParseQueueUrlAndArn(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueUrl": "https://sqs.us-east-1.amazonaws.com/123456789012/queue-a", "deploymentName": "deployment-a"}`)
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueArn": "arn:aws:sqs:eu-west-1:123456789012:queue-b", "deploymentName": "deployment-b"}`)
+	cfgs, err := Load(*f, nil, Globals{Namespace: "default", Region: "us-east-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "https://sqs.us-east-1.amazonaws.com/123456789012/queue-a", cfgs[0].QueueUrl)
+	assert.Equal(t, "us-east-1", cfgs[0].Region)
+	assert.Equal(t, "eu-west-1", cfgs[1].Region, "region should be taken from the queue arn")
+}
+
+func TestValidateQueueIdentifiers(t *testing.T) {
+	tests := []ScalerConfig{
+		{MessagePerPod: 1, MaxPods: 2, KubernetesDeploymentName: "d", QueueName: "a", QueueUrl: "https://example.com/a"},
+		{MessagePerPod: 1, MaxPods: 2, KubernetesDeploymentName: "d", QueueArn: "not-an-arn"},
+		{MessagePerPod: 1, MaxPods: 2, KubernetesDeploymentName: "d", QueueArn: "arn:aws:sns:eu-west-1:123456789012:topic"},
+		{MessagePerPod: 1, MaxPods: 2, KubernetesDeploymentName: "d", QueueUrl: "https://example.com/a", QueueOwnerAccountId: "123456789012"},
+		{MessagePerPod: 1, MaxPods: 2, KubernetesDeploymentName: "d", QueueName: "a", ExternalId: "external"},
+		{MessagePerPod: 1, MaxPods: 2, KubernetesDeploymentName: "d", QueueName: "a", RoleArn: "arn:aws:iam::123456789012:role/r", ExternalId: "external", WebIdentityTokenFile: "/var/run/token"},
+	}
+
+	for _, tt := range tests {
+		assert.NotNil(t, tt.Validate(), fmt.Sprintf("val: %+v", tt))
+	}
+}
+
+func TestParseOnMetricFailure(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "q", "deploymentName": "d", "onMetricFailure": {"policy": "fallback", "replicas": 4, "staleAfter": "5m"}}`)
+	cfgs, err := ParseConfigFlags(*f)
+	assert.Nil(t, err)
+	assert.Equal(t, &MetricFailure{Policy: MetricFailureFallback, Replicas: 4, After: DefaultMetricFailureAfter, StaleAfter: Duration(5 * time.Minute)}, cfgs[0].OnMetricFailure)
+
+	for policy, problem := range map[string]string{
+		`{"policy": "scale"}`:                    "onMetricFailure.policy must be one of hold, fallback or max",
+		`{"policy": "fallback", "replicas": 11}`: "onMetricFailure.replicas must be between 0 and maxPods",
+		`{"policy": "max", "replicas": 2}`:       "onMetricFailure.replicas can only be used with the fallback policy",
+		`{"policy": "hold", "after": -1}`:        "onMetricFailure.after must not be negative",
+	} {
+		f := &ConfigFlag{}
+		f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "q", "deploymentName": "d", "onMetricFailure": ` + policy + `}`)
+		_, err := ParseConfigFlags(*f)
+		if assert.NotNil(t, err, policy) {
+			assert.Contains(t, err.Error(), problem)
+		}
+	}
+}
+
+func TestBudgetGroupFlag(t *testing.T) {
+	groups := BudgetGroupFlag{}
+	assert.Nil(t, groups.Set("name=payments,maxPods=20"))
+	assert.Equal(t, BudgetGroupFlag{{Name: "payments", MaxPods: 20}}, groups)
+
+	for _, value := range []string{"name=payments", "maxPods=20", "name=payments,maxPods=0", "name=payments,maxPods=x", "name=payments,size=2", "payments"} {
+		assert.NotNil(t, groups.Set(value), value)
+	}
+}
+
+func TestLoadValidatesBudgetGroups(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-a", "deploymentName": "deployment-a", "budgetGroup": "payments", "priority": 2}`)
+
+	cfgs, err := Load(*f, nil, Globals{BudgetGroups: BudgetGroupFlag{{Name: "payments", MaxPods: 20}}})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, cfgs[0].Priority)
+
+	_, err = Load(*f, nil, Globals{})
+	assert.EqualError(t, err, "budget group payments of deployment deployment-a isn't defined, use --budget-group")
+
+	_, err = Load(*f, nil, Globals{BudgetGroups: BudgetGroupFlag{{Name: "payments", MaxPods: 20}, {Name: "payments", MaxPods: 10}}})
+	assert.EqualError(t, err, "budget group payments is defined more than once")
+}
+
+func TestLoadValidatesSpendCaps(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-a", "deploymentName": "deployment-a", "budgetGroup": "payments"}`)
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-b", "deploymentName": "deployment-b", "podHourlyCost": 0.12}`)
+
+	groups := BudgetGroupFlag{}
+	assert.Nil(t, groups.Set("name=payments,maxHourlySpend=2.5"))
+	assert.Equal(t, BudgetGroupFlag{{Name: "payments", MaxHourlySpend: 2.5}}, groups)
+
+	_, err := Load(*f, nil, Globals{BudgetGroups: groups})
+	assert.EqualError(t, err, "deployment deployment-a needs a podHourlyCost, its hourly spend is capped")
+
+	f2 := &ConfigFlag{}
+	f2.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "queue-a", "deploymentName": "deployment-a"}`)
+	_, err = Load(*f2, nil, Globals{MaxHourlySpend: 10})
+	assert.EqualError(t, err, "deployment deployment-a needs a podHourlyCost, its hourly spend is capped")
+}
+
+func TestFreezeWindowActive(t *testing.T) {
+	// every Saturday from 02:00 to 06:00 UTC
+	w := FreezeWindow{Mode: FreezeNoScaling, Schedule: "0 2 * * SAT", Duration: Duration(4 * time.Hour)}
+	saturday := time.Date(2026, 10, 24, 0, 0, 0, 0, time.UTC)
+
+	active, _ := w.Active(saturday.Add(time.Hour))
+	assert.False(t, active)
+	active, end := w.Active(saturday.Add(2 * time.Hour))
+	assert.True(t, active)
+	assert.Equal(t, saturday.Add(6*time.Hour), end)
+	active, _ = w.Active(saturday.Add(5*time.Hour + 59*time.Minute))
+	assert.True(t, active)
+	active, _ = w.Active(saturday.Add(6 * time.Hour))
+	assert.False(t, active)
+
+	start, stop := saturday, saturday.Add(48*time.Hour)
+	w = FreezeWindow{Mode: FreezeNoScaleDown, Start: &start, End: &stop}
+	active, end = w.Active(saturday.Add(time.Hour))
+	assert.True(t, active)
+	assert.Equal(t, stop, end)
+	active, _ = w.Active(stop)
+	assert.False(t, active)
+}
+
+func TestParseFreezeWindows(t *testing.T) {
+	f := &ConfigFlag{}
+	f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "q", "deploymentName": "d", "freezeWindows": [
+		{"name": "db maintenance", "mode": "noScaleDown", "schedule": "CRON_TZ=Europe/Berlin 0 2 * * SAT", "duration": "4h"},
+		{"mode": "noScaling", "start": "2026-12-20T00:00:00Z", "end": "2027-01-04T00:00:00Z"}
+	]}`)
+	cfgs, err := ParseConfigFlags(*f)
+	assert.Nil(t, err)
+	assert.Len(t, cfgs[0].FreezeWindows, 2)
+	assert.Equal(t, "db maintenance", cfgs[0].FreezeWindows[0].String())
+	assert.Equal(t, "2026-12-20T00:00:00Z to 2027-01-04T00:00:00Z", cfgs[0].FreezeWindows[1].String())
+
+	for window, problem := range map[string]string{
+		`{"mode": "never", "schedule": "@daily", "duration": "1h"}`:                                      "freezeWindows[0]: mode must be noScaleDown or noScaling",
+		`{"mode": "noScaling", "schedule": "every day", "duration": "1h"}`:                               "freezeWindows[0]: invalid schedule",
+		`{"mode": "noScaling", "schedule": "@daily"}`:                                                    "freezeWindows[0]: a schedule needs a positive duration",
+		`{"mode": "noScaling"}`:                                                                          "freezeWindows[0]: either schedule and duration or start and end are required",
+		`{"mode": "noScaling", "start": "2027-01-04T00:00:00Z", "end": "2026-12-20T00:00:00Z"}`:          "freezeWindows[0]: end must be after start",
+		`{"mode": "noScaling", "schedule": "@daily", "duration": "1h", "start": "2026-12-20T00:00:00Z"}`: "freezeWindows[0]: schedule can't be used with start and end",
+	} {
+		f := &ConfigFlag{}
+		f.Set(`{"messagePerPod": 100, "maxPods": 10, "queueName": "q", "deploymentName": "d", "freezeWindows": [` + window + `]}`)
+		_, err := ParseConfigFlags(*f)
+		if assert.NotNil(t, err, window) {
+			assert.Contains(t, err.Error(), problem)
+		}
+	}
+}
+
+func TestFreezeWindowFlag(t *testing.T) {
+	windows := FreezeWindowFlag{}
+	assert.Nil(t, windows.Set(`{"name": "release freeze", "mode": "noScaling", "start": "2026-12-20T00:00:00Z", "end": "2027-01-04T00:00:00Z"}`))
+	assert.Equal(t, "release freeze", windows[0].Name)
+	assert.EqualError(t, windows.Set(`{"mode": "noScaling"}`), "invalid freeze window: either schedule and duration or start and end are required")
+}
@@ -0,0 +1,121 @@
+package decision
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSize is how many records are kept per deployment.
+const DefaultSize = 20
+
+// Default is the history the scaler loops add their records to.
+var Default = NewHistory(DefaultSize)
+
+// Step is one stage of an evaluation, e.g. clamping to the max pods.
+type Step struct {
+	Name   string `json:"name"`
+	Detail string `json:"detail"`
+}
+
+// Record explains a single evaluation, from the queue attributes read to the
+// action taken.
+type Record struct {
+	Time       time.Time      `json:"time"`
+	Deployment string         `json:"deployment"`
+	Namespace  string         `json:"namespace"`
+	Queue      string         `json:"queue,omitempty"`
+	Inputs     map[string]int `json:"inputs,omitempty"`
+	Backlog    int            `json:"backlog"`
+	Steps      []Step         `json:"steps"`
+	Decision   string         `json:"decision"`
+	Action     string         `json:"action"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func NewStep(name, format string, args ...interface{}) Step {
+	return Step{Name: name, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Step appends a step to the record.
+func (r *Record) Step(name, format string, args ...interface{}) {
+	r.Steps = append(r.Steps, NewStep(name, format, args...))
+}
+
+func (r *Record) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "backlog %d", r.Backlog)
+	for _, s := range r.Steps {
+		fmt.Fprintf(&b, "; %s: %s", s.Name, s.Detail)
+	}
+	fmt.Fprintf(&b, "; action: %s", r.Action)
+	return b.String()
+}
+
+func (r *Record) target() string {
+	return r.Namespace + "/" + r.Deployment
+}
+
+// History keeps the last Size records of every deployment.
+type History struct {
+	Size int
+
+	mu      sync.Mutex
+	records map[string][]Record
+}
+
+func NewHistory(size int) *History {
+	return &History{Size: size, records: map[string][]Record{}}
+}
+
+// Add adds r to the default history.
+func Add(r Record) {
+	Default.Add(r)
+}
+
+func (h *History) Add(r Record) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	records := append(h.records[r.target()], r)
+	if len(records) > h.Size {
+		records = records[len(records)-h.Size:]
+	}
+	h.records[r.target()] = records
+}
+
+// Records returns the records of the deployment, oldest first. deployment is
+// either the name or namespace/name, all records are returned when it's
+// empty.
+func (h *History) Records(deployment string) []Record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	targets := make([]string, 0, len(h.records))
+	for target := range h.records {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	records := []Record{}
+	for _, target := range targets {
+		for _, r := range h.records[target] {
+			if deployment == "" || deployment == target || deployment == r.Deployment {
+				records = append(records, r)
+			}
+		}
+	}
+	return records
+}
+
+// Handler serves /debug/decisions, filtered by the deployment query parameter.
+func (h *History) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.Records(req.URL.Query().Get("deployment")))
+	})
+}
@@ -0,0 +1,51 @@
+package decision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryKeepsLastRecords(t *testing.T) {
+	h := NewHistory(2)
+	for i := 1; i <= 3; i++ {
+		h.Add(Record{Deployment: "deploy", Namespace: "namespace", Backlog: i})
+	}
+	h.Add(Record{Deployment: "other", Namespace: "namespace", Backlog: 10})
+
+	records := h.Records("deploy")
+	assert.Len(t, records, 2)
+	assert.Equal(t, 2, records[0].Backlog)
+	assert.Equal(t, 3, records[1].Backlog)
+
+	assert.Len(t, h.Records("namespace/other"), 1)
+	assert.Len(t, h.Records(""), 3)
+	assert.Empty(t, h.Records("unknown"))
+}
+
+func TestRecordString(t *testing.T) {
+	r := Record{Backlog: 75}
+	r.Step("messagePerPod", "ceil(%d messages / %d per pod) = %d replicas", 75, 20, 4)
+	r.Step("max", "%d capped to the max of %d", 4, 3)
+	r.Action = "scaled up from 1 to 3 replicas"
+
+	assert.Equal(t, "backlog 75; messagePerPod: ceil(75 messages / 20 per pod) = 4 replicas; max: 4 capped to the max of 3; action: scaled up from 1 to 3 replicas", r.String())
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHistory(DefaultSize)
+	h.Add(Record{Deployment: "deploy", Namespace: "namespace", Decision: "cooldown"})
+	h.Add(Record{Deployment: "other", Namespace: "namespace", Decision: "up"})
+
+	rec := httptest.NewRecorder()
+	h.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/decisions?deployment=deploy", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var records []Record
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &records))
+	assert.Len(t, records, 1)
+	assert.Equal(t, "cooldown", records[0].Decision)
+}
@@ -0,0 +1,215 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+const (
+	DefaultReadinessIntervals = 3
+	DefaultLivenessIntervals  = 10
+)
+
+// Default is the registry the scaler loops report to.
+var Default = NewRegistry()
+
+// Registry keeps track of the scaler loops. The process is ready when every
+// loop had a successful poll within ReadinessIntervals poll intervals and
+// alive while no loop stopped or went LivenessIntervals without a poll.
+type Registry struct {
+	ReadinessIntervals int
+	LivenessIntervals  int
+	Clock              clock.PassiveClock
+
+	mu    sync.Mutex
+	loops map[string]*Loop
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		ReadinessIntervals: DefaultReadinessIntervals,
+		LivenessIntervals:  DefaultLivenessIntervals,
+		Clock:              clock.RealClock{},
+		loops:              map[string]*Loop{},
+	}
+}
+
+// Register returns the loop with the given name, creating it on first use.
+func Register(name string, interval time.Duration) *Loop {
+	return Default.Register(name, interval)
+}
+
+func (r *Registry) Register(name string, interval time.Duration) *Loop {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if l, ok := r.loops[name]; ok {
+		return l
+	}
+	l := &Loop{name: name, interval: interval, clock: r.Clock, started: r.Clock.Now()}
+	r.loops[name] = l
+	return l
+}
+
+// Deregister removes a loop that was stopped on purpose, e.g. on lost
+// leadership, so it doesn't count as dead.
+func Deregister(name string) {
+	Default.Deregister(name)
+}
+
+func (r *Registry) Deregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.loops, name)
+}
+
+// Loop is the health of a single scaler loop.
+type Loop struct {
+	name     string
+	interval time.Duration
+	clock    clock.PassiveClock
+
+	mu          sync.Mutex
+	started     time.Time
+	lastPoll    time.Time
+	lastSuccess time.Time
+	stopped     bool
+	reason      string
+}
+
+// Polled records that the loop is still polling, successful or not.
+func (l *Loop) Polled(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastPoll = l.clock.Now()
+	if err == nil {
+		l.lastSuccess = l.lastPoll
+		l.reason = ""
+		return
+	}
+	l.reason = err.Error()
+}
+
+// Stopped marks the loop as dead, e.g. because its goroutine returned.
+func (l *Loop) Stopped(reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stopped = true
+	l.reason = reason
+}
+
+// LoopStatus is the per-loop detail in the health responses.
+type LoopStatus struct {
+	Name               string     `json:"name"`
+	Alive              bool       `json:"alive"`
+	Ready              bool       `json:"ready"`
+	LastPoll           *time.Time `json:"lastPoll,omitempty"`
+	LastSuccessfulPoll *time.Time `json:"lastSuccessfulPoll,omitempty"`
+	Reason             string     `json:"reason,omitempty"`
+}
+
+func (l *Loop) status(now time.Time, readinessIntervals, livenessIntervals int) LoopStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := LoopStatus{Name: l.name, Reason: l.reason}
+	if !l.lastPoll.IsZero() {
+		t := l.lastPoll
+		s.LastPoll = &t
+	}
+	if !l.lastSuccess.IsZero() {
+		t := l.lastSuccess
+		s.LastSuccessfulPoll = &t
+	}
+
+	// a loop that never polled counts as stalled from the time it started
+	lastPoll := l.lastPoll
+	if lastPoll.IsZero() {
+		lastPoll = l.started
+	}
+	stalled := now.Sub(lastPoll) > time.Duration(livenessIntervals)*l.interval
+	s.Alive = !l.stopped && !stalled
+	if stalled && !l.stopped && s.Reason == "" {
+		s.Reason = "no poll within the liveness threshold"
+	}
+
+	s.Ready = s.Alive && !l.lastSuccess.IsZero() &&
+		now.Sub(l.lastSuccess) <= time.Duration(readinessIntervals)*l.interval
+	return s
+}
+
+// Status is the body of the health responses.
+type Status struct {
+	Status string       `json:"status"`
+	Loops  []LoopStatus `json:"loops"`
+}
+
+func (r *Registry) statuses() []LoopStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.Clock.Now()
+	statuses := []LoopStatus{}
+	for _, l := range r.loops {
+		statuses = append(statuses, l.status(now, r.ReadinessIntervals, r.LivenessIntervals))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Alive reports whether every loop is alive.
+func (r *Registry) Alive() (bool, []LoopStatus) {
+	statuses := r.statuses()
+	for _, s := range statuses {
+		if !s.Alive {
+			return false, statuses
+		}
+	}
+	return true, statuses
+}
+
+// Ready reports whether every loop is ready. Without any loops, e.g. on a
+// standby replica waiting for leadership, the process is ready.
+func (r *Registry) Ready() (bool, []LoopStatus) {
+	statuses := r.statuses()
+	for _, s := range statuses {
+		if !s.Ready {
+			return false, statuses
+		}
+	}
+	return true, statuses
+}
+
+// LivenessHandler serves /healthz.
+func (r *Registry) LivenessHandler() http.Handler {
+	return handler(r.Alive)
+}
+
+// ReadinessHandler serves /readyz.
+func (r *Registry) ReadinessHandler() http.Handler {
+	return handler(r.Ready)
+}
+
+func handler(check func() (bool, []LoopStatus)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ok, loops := check()
+		body := Status{Status: "ok", Loops: loops}
+		code := http.StatusOK
+		if !ok {
+			body.Status = "failing"
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(body)
+	})
+}
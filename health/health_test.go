@@ -0,0 +1,107 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func newFakeRegistry() (*Registry, *clock.FakeClock) {
+	clk := clock.NewFakeClock(time.Now())
+	r := NewRegistry()
+	r.Clock = clk
+	return r, clk
+}
+
+func TestReadyAfterSuccessfulPoll(t *testing.T) {
+	r, clk := newFakeRegistry()
+	ready, _ := r.Ready()
+	assert.True(t, ready, "no loops are running")
+
+	l := r.Register("namespace/deploy", time.Second)
+	ready, _ = r.Ready()
+	assert.False(t, ready, "loop didn't poll yet")
+
+	l.Polled(errors.New("queue unreachable"))
+	ready, statuses := r.Ready()
+	assert.False(t, ready)
+	assert.Equal(t, "queue unreachable", statuses[0].Reason)
+
+	l.Polled(nil)
+	ready, _ = r.Ready()
+	assert.True(t, ready)
+
+	clk.Step(3 * time.Second)
+	ready, _ = r.Ready()
+	assert.True(t, ready, "last successful poll is exactly at the readiness threshold")
+
+	clk.Step(time.Millisecond)
+	ready, _ = r.Ready()
+	assert.False(t, ready, "last successful poll is older than the readiness intervals")
+}
+
+func TestNotAliveWhenLoopStoppedOrStalled(t *testing.T) {
+	r, clk := newFakeRegistry()
+	a := r.Register("a", time.Second)
+	b := r.Register("b", time.Second)
+	a.Polled(nil)
+	b.Polled(nil)
+	assert.Same(t, a, r.Register("a", time.Second))
+
+	alive, _ := r.Alive()
+	assert.True(t, alive)
+
+	clk.Step(11 * time.Second)
+	a.Polled(nil)
+	alive, statuses := r.Alive()
+	assert.False(t, alive)
+	assert.True(t, statuses[0].Alive)
+	assert.False(t, statuses[1].Alive)
+	assert.Equal(t, "no poll within the liveness threshold", statuses[1].Reason)
+
+	b.Polled(nil)
+	a.Stopped("loop exited")
+	alive, statuses = r.Alive()
+	assert.False(t, alive)
+	assert.Equal(t, "loop exited", statuses[0].Reason)
+}
+
+func TestHandlers(t *testing.T) {
+	r := NewRegistry()
+	l := r.Register("namespace/deploy", time.Second)
+
+	rec := httptest.NewRecorder()
+	r.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	l.Polled(nil)
+	rec = httptest.NewRecorder()
+	r.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body Status
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "ok", body.Status)
+	assert.Equal(t, "namespace/deploy", body.Loops[0].Name)
+	assert.True(t, body.Loops[0].Ready)
+	assert.NotNil(t, body.Loops[0].LastSuccessfulPoll)
+}
+
+func TestDeregister(t *testing.T) {
+	r := NewRegistry()
+	l := r.Register("a", time.Second)
+	l.Stopped("loop exited")
+	alive, _ := r.Alive()
+	assert.False(t, alive)
+
+	r.Deregister("a")
+	alive, statuses := r.Alive()
+	assert.True(t, alive)
+	assert.Empty(t, statuses)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"kube-sqs-autoscaler/admin"
+	"kube-sqs-autoscaler/decision"
+	"kube-sqs-autoscaler/health"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// newServeMux serves the metrics, health and debug endpoints, and the admin
+// API when adminToken is set.
+func newServeMux(adminToken string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", health.Default.LivenessHandler())
+	mux.Handle("/readyz", health.Default.ReadinessHandler())
+	mux.Handle("/debug/decisions", decision.Default.Handler())
+	if adminToken != "" {
+		mux.Handle("/admin/", admin.Default.Handler(adminToken))
+	}
+	return mux
+}
+
+func serveHTTP(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		log.Infof("[autoscaler] Serving http on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("[autoscaler] Failed to serve http on %s: %v", addr, err)
+		}
+	}()
+	return server
+}
+
+// readAdminToken reads the admin API token from path, an empty path turns the
+// admin API off.
+func readAdminToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read the admin token: %v", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("Admin token file %s is empty", path)
+	}
+	return token, nil
+}
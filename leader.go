@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+type leaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+}
+
+// validate checks the lease timings the way leaderelection.RunOrDie does, so
+// inconsistent flags are reported instead of panicking.
+func (c leaderElectionConfig) validate() error {
+	switch {
+	case c.LeaseName == "":
+		return errors.New("--leader-elect-lease-name must not be empty")
+	case c.LeaseDuration <= 0 || c.RenewDeadline <= 0 || c.RetryPeriod <= 0:
+		return errors.New("--leader-elect-lease-duration, --leader-elect-renew-deadline and --leader-elect-retry-period must be positive")
+	case c.LeaseDuration <= c.RenewDeadline:
+		return fmt.Errorf("--leader-elect-lease-duration (%s) must be greater than --leader-elect-renew-deadline (%s)", c.LeaseDuration, c.RenewDeadline)
+	case float64(c.RenewDeadline) <= leaderelection.JitterFactor*float64(c.RetryPeriod):
+		return fmt.Errorf("--leader-elect-renew-deadline (%s) must be greater than %.1f times --leader-elect-retry-period (%s)", c.RenewDeadline, leaderelection.JitterFactor, c.RetryPeriod)
+	}
+	return nil
+}
+
+// leaseNamespace is the namespace of the lease, --kubernetes-namespace by
+// default.
+func (c leaderElectionConfig) leaseNamespace() string {
+	if c.LeaseNamespace == "" {
+		return kubernetesNamespace
+	}
+	return c.LeaseNamespace
+}
+
+// runWithLeaderElection runs lead whenever this replica holds the lease. The
+// context passed to lead is cancelled when leadership is lost, after which the
+// replica campaigns for the lease again. It returns once ctx is cancelled and
+// lead returned. id identifies this replica in the lease.
+func runWithLeaderElection(ctx context.Context, client kubernetes.Interface, id string, lead func(context.Context)) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaderElection.LeaseName,
+			Namespace: leaderElection.leaseNamespace(),
+		},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: id},
+	}
+
+	// a new term only starts leading once the loops of the previous term
+	// stopped, so two terms never scale at the same time
+	var leading sync.Mutex
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaderElection.LeaseDuration,
+			RenewDeadline:   leaderElection.RenewDeadline,
+			RetryPeriod:     leaderElection.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            leaderElection.LeaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					leading.Lock()
+					defer leading.Unlock()
+					log.Infof("[autoscaler] %s became the leader, starting scaler loops", id)
+					lead(ctx)
+				},
+				OnStoppedLeading: func() {
+					log.Infof("[autoscaler] %s is not leading, scaler loops are stopped", id)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != id {
+						log.Infof("[autoscaler] %s is the leader", identity)
+					}
+				},
+			},
+		})
+	}
+
+	// wait for the loops of the last term
+	leading.Lock()
+	leading.Unlock()
+}
+
+func leaderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "kube-sqs-autoscaler"
+	}
+	return hostname + "_" + string(uuid.NewUUID())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	fake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElectionFailover(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	leaderElection = leaderElectionConfig{
+		LeaseName:     "kube-sqs-autoscaler",
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+	client := fake.NewSimpleClientset()
+
+	var leaders int32
+	lead := func(running *int32) func(context.Context) {
+		return func(ctx context.Context) {
+			atomic.StoreInt32(running, 1)
+			atomic.AddInt32(&leaders, 1)
+			<-ctx.Done()
+			atomic.AddInt32(&leaders, -1)
+			atomic.StoreInt32(running, 0)
+		}
+	}
+
+	var firstRunning, secondRunning int32
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		runWithLeaderElection(firstCtx, client, "first", lead(&firstRunning))
+		close(firstDone)
+	}()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&firstRunning) == 1 }, 5*time.Second, 50*time.Millisecond)
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go runWithLeaderElection(secondCtx, client, "second", lead(&secondRunning))
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&secondRunning), "standby must not run the scalers")
+
+	stopFirst()
+	<-firstDone
+	assert.Equal(t, int32(0), atomic.LoadInt32(&firstRunning), "scalers are stopped before returning")
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&secondRunning) == 1 }, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&leaders))
+}
+
+func TestLeaderElectionConfigValidate(t *testing.T) {
+	valid := leaderElectionConfig{LeaseName: "lease", LeaseDuration: 15 * time.Second, RenewDeadline: 10 * time.Second, RetryPeriod: 2 * time.Second}
+	assert.Nil(t, valid.validate())
+
+	renewTooLong := valid
+	renewTooLong.RenewDeadline = 15 * time.Second
+	assert.EqualError(t, renewTooLong.validate(), "--leader-elect-lease-duration (15s) must be greater than --leader-elect-renew-deadline (15s)")
+
+	retryTooLong := valid
+	retryTooLong.RetryPeriod = 9 * time.Second
+	assert.EqualError(t, retryTooLong.validate(), "--leader-elect-renew-deadline (10s) must be greater than 1.2 times --leader-elect-retry-period (9s)")
+
+	zero := valid
+	zero.RetryPeriod = 0
+	assert.NotNil(t, zero.validate())
+
+	unnamed := valid
+	unnamed.LeaseName = ""
+	assert.NotNil(t, unnamed.validate())
+}
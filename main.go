@@ -5,104 +5,253 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
+	"kube-sqs-autoscaler/budget"
 	"kube-sqs-autoscaler/config"
+	"kube-sqs-autoscaler/decision"
+	"kube-sqs-autoscaler/health"
+	"kube-sqs-autoscaler/metrics"
+	"kube-sqs-autoscaler/retry"
 	"kube-sqs-autoscaler/scale"
 	kubesqs "kube-sqs-autoscaler/sqs"
 
 	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
+const shutdownTimeout = 5 * time.Second
+
 var (
 	awsRegion           string
+	awsEndpoint         string
+	awsStsEndpoint      string
 	kubernetesNamespace string
 	dryRun              bool
+	listenAddress       string
+	readinessIntervals  int
+	livenessIntervals   int
+	decisionHistory     int
+	adminTokenFile      string
+	conflictWindow      time.Duration
+	leaderElect         bool
+	statusAnnotations   bool
+	persistCoolDowns    bool
+	logFormat           string
+	logLevel            string
+	retryPolicy         retry.Policy
+	maxTotalPods        int
+	maxHourlySpend      float64
+	budgetGroups        config.BudgetGroupFlag
+	freezeWindows       config.FreezeWindowFlag
+	leaderElection      leaderElectionConfig
 )
 
-type ScalingTimeDiff struct {
-	t              *time.Time
-	CoolDownPeriod config.Duration
-}
+func main() {
+	command, args := splitCommand(os.Args[1:])
 
-func (s *ScalingTimeDiff) CoolDownPassed() bool {
-	if s.t == nil {
-		t := time.Now()
-		s.t = &t
-		return false
+	var configs, configFiles config.ConfigFlag
+	var outputFormat string
+	flag.Var(&configs, "config", "Scaler config as JSON, can be given multiple times")
+	flag.Var(&configFiles, "config-file", "Path to a JSON or YAML file with one or more scaler configs, can be given multiple times")
+	flag.StringVar(&kubernetesNamespace, "kubernetes-namespace", "default", "The namespace your deployment is running in")
+	flag.StringVar(&awsRegion, "aws-region", "", "Your AWS region")
+	flag.StringVar(&awsEndpoint, "aws-endpoint", os.Getenv("AWS_ENDPOINT"), "Custom SQS endpoint, e.g. for elasticmq. Defaults to the AWS_ENDPOINT env var")
+	flag.StringVar(&awsStsEndpoint, "aws-sts-endpoint", "", "Custom STS endpoint used to assume the roles given in configs")
+	flag.BoolVar(&dryRun, "dry-run", true, "if scaling should run on dry-run mode or not")
+	flag.StringVar(&listenAddress, "listen-address", ":8080", "Address to serve /metrics, /healthz, /readyz and /debug/decisions on")
+	flag.IntVar(&readinessIntervals, "readiness-intervals", health.DefaultReadinessIntervals, "Poll intervals a scaler loop may go without a successful poll before the autoscaler isn't ready")
+	flag.IntVar(&livenessIntervals, "liveness-intervals", health.DefaultLivenessIntervals, "Poll intervals a scaler loop may go without polling before the autoscaler isn't alive")
+	flag.IntVar(&decisionHistory, "decision-history", decision.DefaultSize, "Scaling decisions kept per deployment for /debug/decisions")
+	flag.StringVar(&adminTokenFile, "admin-token-file", "", "File holding the bearer token of the /admin API. The admin API is off without it")
+	flag.DurationVar(&conflictWindow, "conflict-window", scale.DefaultConflictWindow, "How long after another manager changed the replicas of a deployment it isn't scaled. 0 only checks for HorizontalPodAutoscalers")
+	flag.IntVar(&retryPolicy.MaxAttempts, "retry-max-attempts", retry.DefaultMaxAttempts, "Attempts of each SQS and Kubernetes call before a poll fails. 1 disables retries")
+	flag.DurationVar(&retryPolicy.BaseDelay, "retry-base-delay", retry.DefaultBaseDelay, "Delay before the first retry, doubled for each further retry")
+	flag.DurationVar(&retryPolicy.MaxDelay, "retry-max-delay", retry.DefaultMaxDelay, "Upper bound of the delay between retries")
+	flag.Float64Var(&retryPolicy.Jitter, "retry-jitter", retry.DefaultJitter, "Fraction of each retry delay that is randomized, between 0 and 1")
+	flag.IntVar(&maxTotalPods, "max-total-pods", 0, "Replicas all scaled deployments may have together, shared by backlog and priority when exceeded. 0 is no limit")
+	flag.Float64Var(&maxHourlySpend, "max-hourly-spend", 0, "Hourly cost all scaled deployments may have together, by the podHourlyCost of their configs. 0 is no limit")
+	flag.Var(&budgetGroups, "budget-group", "Budget group configs can be assigned to as name=<name>,maxPods=<n>,maxHourlySpend=<x>, can be given multiple times")
+	flag.Var(&freezeWindows, "freeze-window", "Freeze window of all deployments as JSON, like the freezeWindows of configs, can be given multiple times")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Only scale from the replica holding the leader election lease, so several replicas can run")
+	flag.StringVar(&leaderElection.LeaseName, "leader-elect-lease-name", "kube-sqs-autoscaler", "Name of the leader election lease")
+	flag.StringVar(&leaderElection.LeaseNamespace, "leader-elect-lease-namespace", "", "Namespace of the leader election lease. Defaults to --kubernetes-namespace")
+	flag.DurationVar(&leaderElection.LeaseDuration, "leader-elect-lease-duration", 15*time.Second, "How long standby replicas wait before taking over a lease that wasn't renewed")
+	flag.DurationVar(&leaderElection.RenewDeadline, "leader-elect-renew-deadline", 10*time.Second, "How long the leader keeps trying to renew the lease before it gives up leadership")
+	flag.DurationVar(&leaderElection.RetryPeriod, "leader-elect-retry-period", 2*time.Second, "How often replicas try to acquire or renew the lease")
+	flag.BoolVar(&statusAnnotations, "status-annotations", false, "Annotate each deployment with the backlog, desired replicas and cooldown of its last poll")
+	flag.BoolVar(&persistCoolDowns, "persist-cooldowns", false, "Keep the cooldown timers in deployment annotations so they survive autoscaler restarts")
+	flag.StringVar(&logFormat, "log-format", "text", "Log format, text or json")
+	flag.StringVar(&logLevel, "log-level", "info", "Log level, one of debug, info, warn or error")
+	flag.StringVar(&outputFormat, "output", "json", "Output format for print-config, json or yaml")
+	flag.Usage = usage
+	flag.CommandLine.Parse(args)
+
+	if err := configureLogging(log.StandardLogger(), logFormat, logLevel); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	return s.t.Add(s.CoolDownPeriod.ToDuration()).Before(time.Now())
-}
+	if err := validateFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-func (s *ScalingTimeDiff) Reset() {
-	s.t = nil
-}
+	if leaderElect {
+		if err := leaderElection.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
 
-func Run(p *scale.PodAutoScaler, sqs *kubesqs.SqsClient, cfg *config.ScalerConfig) {
-	ctx := context.Background()
-	lastScalingTime := &ScalingTimeDiff{CoolDownPeriod: cfg.CoolDownPeriod}
-	zeroScalingTime := &ScalingTimeDiff{CoolDownPeriod: cfg.ZeroScalingCoolDown}
+	switch command {
+	case cmdValidate:
+		os.Exit(validate(os.Stdout, configs, configFiles))
+	case cmdPrintConfig:
+		os.Exit(printConfig(os.Stdout, configs, configFiles, outputFormat))
+	case cmdRun:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", command)
+		usage()
+		os.Exit(2)
+	}
 
-	pollInterval := cfg.PollInterval.ToDuration()
-	for {
-		time.Sleep(pollInterval)
+	parsedConfigs, err := config.Load(configs, configFiles, globals())
+	if err != nil {
+		log.Errorf("[autoscaler] Failed to parse config flags. err: %s", err)
+		os.Exit(1)
+	}
 
-		numMessages, err := sqs.NumMessages()
-		if err != nil {
-			log.Errorf("[autoscaler] Failed to get SQS messages: %v", err)
-			continue
-		}
+	ctx, cancel := signalContext()
+	defer cancel()
 
-		if numMessages == 0 && zeroScalingTime.CoolDownPassed() == false {
-			log.Info("[autoscaler] Have 0 messages but waiting for cooldown period")
-			continue
-		}
+	health.Default.ReadinessIntervals = readinessIntervals
+	health.Default.LivenessIntervals = livenessIntervals
+	decision.Default.Size = decisionHistory
+	budget.Default.Total = budget.Limits{MaxPods: maxTotalPods, MaxHourlySpend: maxHourlySpend}
+	budget.Default.ReportTimeout = reportTimeout(parsedConfigs)
+	for _, g := range budgetGroups {
+		budget.Default.Groups[g.Name] = budget.Limits{MaxPods: g.MaxPods, MaxHourlySpend: g.MaxHourlySpend}
+	}
+	adminToken, err := readAdminToken(adminTokenFile)
+	if err != nil {
+		log.Errorf("[autoscaler] %v", err)
+		os.Exit(1)
+	}
+	server := serveHTTP(listenAddress, newServeMux(adminToken))
 
-		if numMessages > 0 && lastScalingTime.CoolDownPassed() == false {
-			log.Infof("[autoscaler] Waiting for cooldown period to pass. current num of messages: %d", numMessages)
-			continue
-		}
-		scalingResult := p.Scale(ctx, numMessages)
-		if scalingResult.Err != nil {
-			log.Errorf("[autoscaler] Failed scale: %v", err)
-			continue
-		}
+	kubeClient := scale.NewKubeClient()
+	recorder, stopRecorder := scale.NewEventRecorder(kubeClient)
+	defer stopRecorder()
+	id := leaderIdentity()
+	if leaderElect {
+		runWithLeaderElection(ctx, kubeClient, id, func(ctx context.Context) {
+			runScalers(ctx, kubeClient, recorder, id, parsedConfigs)
+		})
+	} else {
+		runScalers(ctx, kubeClient, recorder, id, parsedConfigs)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	server.Shutdown(shutdownCtx)
+
+	if ctx.Err() == nil {
+		// the loops stopped without being asked to
+		log.Error("[autoscaler] All scaler loops stopped, exiting")
+		os.Exit(1)
+	}
+	log.Info("[autoscaler] Shut down cleanly")
+}
 
-		if !scalingResult.ScalingSkipped {
-			lastScalingTime.Reset()
-			zeroScalingTime.Reset()
+// signalContext returns a context that is cancelled on SIGTERM or SIGINT. A
+// second signal exits right away.
+// reportTimeout is how long the budgets wait for a deployment to report its
+// replicas: a few poll intervals of the slowest scaler, but at least
+// budget.DefaultReportTimeout.
+func reportTimeout(configs config.ScalerConfigs) time.Duration {
+	timeout := budget.DefaultReportTimeout
+	for _, c := range configs {
+		if d := 3 * c.PollInterval.ToDuration(); d > timeout {
+			timeout = d
 		}
 	}
+	return timeout
 }
 
-func main() {
-	var configs config.ConfigFlag
-	flag.Var(&configs, "config", "")
-	flag.StringVar(&kubernetesNamespace, "kubernetes-namespace", "default", "The namespace your deployment is running in")
-	flag.StringVar(&awsRegion, "aws-region", "", "Your AWS region")
-	flag.BoolVar(&dryRun, "dry-run", true, "if scaling should run on dry-run mode or not")
-	flag.Parse()
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Infof("[autoscaler] Received %s, shutting down", sig)
+		cancel()
 
-	parsedConfigs, err := config.ParseConfigFlags(configs)
-	if err != nil {
-		log.Errorf("[autoscaler] Failed to parse config flags. err: %s", err)
+		sig = <-signals
+		log.Errorf("[autoscaler] Received %s again, exiting", sig)
 		os.Exit(1)
-	}
+	}()
+	return ctx, cancel
+}
 
-	for _, c := range parsedConfigs {
+// runScalers starts a loop for each tracked deployment and waits until all of
+// them stopped.
+func runScalers(ctx context.Context, kubeClient kubernetes.Interface, recorder record.EventRecorder, instanceId string, configs config.ScalerConfigs) {
+	var wg sync.WaitGroup
+	for _, c := range configs {
+		wg.Add(1)
 		// start a go routine for each tracked deployment
 		go func(conf *config.ScalerConfig) {
-			p := scale.NewPodAutoScaler(conf.KubernetesDeploymentName, kubernetesNamespace, conf.MaxPods, 1, conf.MessagePerPod, conf.ZeroScaling, dryRun)
-			sqs := kubesqs.NewSqsClient(conf.QueueName, awsRegion)
+			defer wg.Done()
+			p := scale.NewPodAutoScaler(kubeClient, conf.KubernetesDeploymentName, conf.Namespace, conf.MaxPods, 1, conf.MessagePerPod, conf.ZeroScaling, dryRun)
+			p.Recorder = recorder
+			p.StatusAnnotations = statusAnnotations
+			p.InstanceId = instanceId
+			p.PersistCoolDowns = persistCoolDowns
+			p.ConflictWindow = conflictWindow
+			p.IgnoreConflicts = conf.IgnoreConflicts
+			p.ManualOverrideHold = conf.ManualOverrideHold.ToDuration()
+			p.ReadinessAware = conf.ReadinessAware
+			p.FreezeWindows = append(append([]config.FreezeWindow{}, freezeWindows...), conf.FreezeWindows...)
+			p.CapacityAware = conf.CapacityAware
+			p.CapacityHeadroom = conf.CapacityHeadroom
+			p.Retry = retryPolicy
+			p.Budget = budget.Join(conf.Target(), conf.BudgetGroup, conf.Priority, conf.PodHourlyCost)
+			defer p.Budget.Leave()
+			p.Log = log.WithFields(log.Fields{
+				"deployment": conf.KubernetesDeploymentName,
+				"namespace":  conf.Namespace,
+				"queue":      conf.QueueIdentifier(),
+			})
+			queue := kubesqs.Queue{Name: conf.QueueName, Url: conf.QueueUrl, Arn: conf.QueueArn, OwnerAccountId: conf.QueueOwnerAccountId}
+			sqs, err := kubesqs.NewSqsClient(queue, kubesqs.ClientOptions{
+				Region:               conf.Region,
+				Endpoint:             conf.Endpoint,
+				RoleArn:              conf.RoleArn,
+				ExternalId:           conf.ExternalId,
+				WebIdentityTokenFile: conf.WebIdentityTokenFile,
+				StsEndpoint:          conf.StsEndpoint,
+			})
+			if err != nil {
+				p.Logger().Errorf("[autoscaler] Failed to create SQS client: %v", err)
+				health.Register(conf.Target(), conf.PollInterval.ToDuration()).Stopped(err.Error())
+				return
+			}
+			sqs.Retry = retryPolicy
+			m := metrics.NewScaler(conf.Namespace, conf.KubernetesDeploymentName, conf.QueueIdentifier())
+			p.Metrics = m
+			sqs.Metrics = m
 
-			log.Info(fmt.Sprintf("[autoscaler] Starting kube-sqs-autoscaler for %s", conf.KubernetesDeploymentName))
-			Run(p, sqs, conf)
+			p.Logger().Info("[autoscaler] Starting kube-sqs-autoscaler")
+			Run(ctx, clock.RealClock{}, p, sqs, conf)
+			p.Logger().Info("[autoscaler] Stopped kube-sqs-autoscaler")
 		}(c)
 	}
-
-	for {
-		time.Sleep(10 * time.Second)
-		log.Info("[autoscaler] health tick")
-	}
+	wg.Wait()
 }
@@ -2,24 +2,51 @@ package main
 
 import (
 	"context"
+	"errors"
+	"kube-sqs-autoscaler/admin"
+	"kube-sqs-autoscaler/budget"
 	"kube-sqs-autoscaler/config"
+	"kube-sqs-autoscaler/decision"
 	"kube-sqs-autoscaler/scale"
 	kubesqs "kube-sqs-autoscaler/sqs"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/stretchr/testify/assert"
 
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	fake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
+func TestScalingTimeDiff(t *testing.T) {
+	clk := clock.NewFakeClock(time.Now())
+	s := &ScalingTimeDiff{CoolDownPeriod: config.Duration(5 * time.Second), Clock: clk}
+	assert.Equal(t, 5*time.Second, s.Remaining(), "the full period is left before the timer starts")
+
+	assert.False(t, s.CoolDownPassed(), "the first call starts the timer")
+	clk.Step(2 * time.Second)
+	assert.False(t, s.CoolDownPassed())
+	assert.Equal(t, 3*time.Second, s.Remaining())
+
+	clk.Step(3 * time.Second)
+	assert.False(t, s.CoolDownPassed(), "the cooldown passes only after the full period")
+	assert.Equal(t, time.Duration(0), s.Remaining())
+
+	clk.Step(time.Nanosecond)
+	assert.True(t, s.CoolDownPassed())
+
+	s.Reset()
+	assert.False(t, s.CoolDownPassed(), "the timer starts again after a reset")
+}
+
 func TestRunScaleUpCoolDown(t *testing.T) {
-	ctx := context.Background()
-	awsRegion = "us-east-1"
 	kubernetesNamespace = "namespace"
 	initPods := 3
 	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, initPods)
@@ -36,21 +63,18 @@ func TestRunScaleUpCoolDown(t *testing.T) {
 		},
 	})
 	c := NewScalerConfig(1*time.Second, 5*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
-	go Run(p, s, c)
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
 
-	time.Sleep(3 * time.Second)
-	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
-	assert.Equal(t, int32(3), *deployment.Spec.Replicas, "Number of replicas should be 3 before the cool down period")
+	// the first poll starts the cooldown, which passes 5s later on the 7th poll
+	poll(t, clk, c, 6)
+	assert.Equal(t, int32(3), replicas(p), "Number of replicas should be 3 before the cool down period")
 
-	time.Sleep(5 * time.Second)
-	deployment, _ = p.Client.Get(ctx, "deploy", metav1.GetOptions{})
-	assert.Equal(t, int32(5), *deployment.Spec.Replicas, "Number of replicas should be 5 after the cool down period")
+	poll(t, clk, c, 1)
+	assert.Equal(t, int32(5), replicas(p), "Number of replicas should be 5 after the cool down period")
 }
 
 func TestRunScaleDownCoolDown(t *testing.T) {
-	ctx := context.Background()
-
-	awsRegion = "us-east-1"
 	kubernetesNamespace = "namespace"
 	initPods := 3
 	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, initPods)
@@ -67,20 +91,17 @@ func TestRunScaleDownCoolDown(t *testing.T) {
 		},
 	})
 	c := NewScalerConfig(1*time.Second, 5*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
-	go Run(p, s, c)
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
 
-	time.Sleep(3 * time.Second)
-	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
-	assert.Equal(t, int32(3), *deployment.Spec.Replicas, "Number of replicas should be 3 before the cool down period")
+	poll(t, clk, c, 6)
+	assert.Equal(t, int32(3), replicas(p), "Number of replicas should be 3 before the cool down period")
 
-	time.Sleep(5 * time.Second)
-	deployment, _ = p.Client.Get(ctx, "deploy", metav1.GetOptions{})
-	assert.Equal(t, int32(1), *deployment.Spec.Replicas, "Number of replicas should be 1 after the cool down period")
+	poll(t, clk, c, 1)
+	assert.Equal(t, int32(1), replicas(p), "Number of replicas should be 1 after the cool down period")
 }
 
 func TestRunReachOneReplicaWithScaleing(t *testing.T) {
-	ctx := context.Background()
-	awsRegion = "us-east-1"
 	kubernetesNamespace = "namespace"
 	initPods := 3
 	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, initPods)
@@ -97,17 +118,14 @@ func TestRunReachOneReplicaWithScaleing(t *testing.T) {
 		},
 	})
 	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
-	go Run(p, s, c)
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
 
-	time.Sleep(3 * time.Second)
-	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
-	assert.Equal(t, int32(1), *deployment.Spec.Replicas, "Number of replicas should be the min")
+	poll(t, clk, c, 3)
+	assert.Equal(t, int32(1), replicas(p), "Number of replicas should be the min")
 }
 
 func TestRunReachMaxReplicasWithScaleing(t *testing.T) {
-	ctx := context.Background()
-
-	awsRegion = "us-east-1"
 	kubernetesNamespace = "namespace"
 	initPods := 3
 	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, initPods)
@@ -124,11 +142,432 @@ func TestRunReachMaxReplicasWithScaleing(t *testing.T) {
 		},
 	})
 	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
-	go Run(p, s, c)
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 3)
+	assert.Equal(t, int32(100), replicas(p), "Number of replicas should be the max")
+}
+
+func TestRunZeroScalingCoolDown(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, 3)
+	p.ZeroScaling = true
+	s := NewMockSqsClient([]map[string]*string{
+		{
+			"ApproximateNumberOfMessages":           aws.String("0"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+	})
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 100, true, 10*time.Second, "example-queue", "deploy")
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 11)
+	assert.Equal(t, int32(3), replicas(p), "Number of replicas should be kept until the zero scaling cool down passed")
+
+	poll(t, clk, c, 1)
+	assert.Equal(t, int32(0), replicas(p), "Number of replicas should be 0 after the zero scaling cool down")
+}
+
+func TestRunCoolDownStartsAgainAfterScaling(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, 3)
+	s := NewMockSqsClient([]map[string]*string{
+		{
+			"ApproximateNumberOfMessages":           aws.String("100"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+		{
+			"ApproximateNumberOfMessages":           aws.String("100"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+		{
+			"ApproximateNumberOfMessages":           aws.String("100"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+		{
+			"ApproximateNumberOfMessages":           aws.String("200"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+	})
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 3)
+	assert.Equal(t, int32(5), replicas(p))
+
+	// the 4th poll starts the next cooldown, which passes on the 6th poll
+	poll(t, clk, c, 2)
+	assert.Equal(t, int32(5), replicas(p))
+	poll(t, clk, c, 1)
+	assert.Equal(t, int32(10), replicas(p))
+}
+
+func TestRunRecordsQueueUnreachable(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, 3)
+	recorder := record.NewFakeRecorder(10)
+	p.Recorder = recorder
+	s := NewMockSqsClient([]map[string]*string{})
+	s.Client.(*MockSQS).Err = errors.New("connection refused")
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 1)
+	assert.Contains(t, <-recorder.Events, "Warning QueueUnreachable Failed to get the number of messages in the queue")
+	assert.Equal(t, int32(3), replicas(p))
+}
+
+func TestRunFallsBackOnMetricFailure(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 10, 1, 3)
+	recorder := record.NewFakeRecorder(10)
+	p.Recorder = recorder
+	s := NewMockSqsClient([]map[string]*string{
+		{
+			"ApproximateNumberOfMessages":           aws.String("20"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+	})
+	s.Client.(*MockSQS).Err = errors.New("connection refused")
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 10, false, 20*time.Second, "example-queue", "deploy")
+	c.OnMetricFailure = &config.MetricFailure{Policy: config.MetricFailureFallback, Replicas: 6, After: 3}
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 2)
+	assert.Equal(t, int32(3), replicas(p))
+	poll(t, clk, c, 1)
+	assert.Equal(t, int32(6), replicas(p))
+	assert.Contains(t, drainEvents(recorder), "Warning MetricFailure The backlog couldn't be read, 3 reads in a row failed")
+
+	// once the backlog can be read it counts again
+	s.Client.(*MockSQS).setErr(nil)
+	poll(t, clk, c, 3)
+	assert.Equal(t, int32(1), replicas(p))
+}
+
+func TestRunScalesToMaxOnStaleBacklog(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 10, 1, 3)
+	s := NewMockSqsClient([]map[string]*string{})
+	s.Client.(*MockSQS).Err = errors.New("connection refused")
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 10, false, 20*time.Second, "example-queue", "deploy")
+	c.OnMetricFailure = &config.MetricFailure{Policy: config.MetricFailureMax, After: 100, StaleAfter: config.Duration(5 * time.Second)}
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 4)
+	assert.Equal(t, int32(3), replicas(p))
+	poll(t, clk, c, 1)
+	assert.Equal(t, int32(10), replicas(p))
+}
 
-	time.Sleep(3 * time.Second)
-	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
-	assert.Equal(t, int32(100), *deployment.Spec.Replicas, "Number of replicas should be the max")
+func TestRunHoldsOnMetricFailure(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 10, 1, 3)
+	s := NewMockSqsClient([]map[string]*string{})
+	s.Client.(*MockSQS).Err = errors.New("connection refused")
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 10, false, 20*time.Second, "example-queue", "deploy")
+	c.OnMetricFailure = &config.MetricFailure{Policy: config.MetricFailureHold, After: 1}
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 5)
+	assert.Equal(t, int32(3), replicas(p))
+}
+
+func TestRunReportsReplicasToBudgetsDuringCoolDown(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	clk := clock.NewFakeClock(time.Now())
+	coordinator := budget.NewCoordinator()
+	coordinator.Clock = clk
+	coordinator.Total.MaxPods = 10
+	coordinator.ReportTimeout = time.Hour
+	backlog := []map[string]*string{{
+		"ApproximateNumberOfMessages":           aws.String("200"),
+		"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+		"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+	}}
+
+	// b has 5 replicas and never gets past its first cooldown
+	b := NewMockPodAutoScaler("deploy", kubernetesNamespace, 10, 1, 5)
+	b.Budget = coordinator.Join("b", "", 1, 0)
+	bClock := clock.NewFakeClock(time.Now())
+	bConfig := NewScalerConfig(1*time.Second, time.Hour, 20, 10, false, time.Hour, "b-queue", "deploy")
+	bConfig.Namespace = "b"
+	defer startRun(bClock, b, NewMockSqsClient(backlog), bConfig)()
+	waitForPoll(t, bClock)
+
+	a := NewMockPodAutoScaler("deploy", kubernetesNamespace, 10, 1, 2)
+	a.Budget = coordinator.Join("a", "", 1, 0)
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 10, false, 20*time.Second, "example-queue", "deploy")
+	defer startRun(clk, a, NewMockSqsClient(backlog), c)()
+
+	poll(t, clk, c, 3)
+	assert.Equal(t, int32(5), replicas(a), "scaled up to what b leaves")
+}
+
+func TestRunStopsWaitingForBudgetsToReport(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	clk := clock.NewFakeClock(time.Now())
+	coordinator := budget.NewCoordinator()
+	coordinator.Clock = clk
+	coordinator.Total.MaxPods = 10
+	coordinator.ReportTimeout = 5 * time.Second
+	// the deployment of the other member can't be read, so it never reports
+	coordinator.Join("unreadable", "", 1, 0)
+
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 10, 1, 2)
+	p.Budget = coordinator.Join("deploy", "", 1, 0)
+	s := NewMockSqsClient([]map[string]*string{{
+		"ApproximateNumberOfMessages":           aws.String("200"),
+		"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+		"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+	}})
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 10, false, 20*time.Second, "example-queue", "deploy")
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 3)
+	assert.Equal(t, int32(2), replicas(p), "waiting for the other member to report")
+	poll(t, clk, c, 3)
+	assert.Equal(t, int32(10), replicas(p))
+}
+
+func drainEvents(recorder *record.FakeRecorder) string {
+	events := ""
+	for {
+		select {
+		case e := <-recorder.Events:
+			events += e + "\n"
+		default:
+			return events
+		}
+	}
+}
+
+func TestRunWritesStatus(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, 3)
+	p.StatusAnnotations = true
+	p.InstanceId = "pod-1"
+	s := NewMockSqsClient([]map[string]*string{
+		{
+			"ApproximateNumberOfMessages":           aws.String("100"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+	})
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
+	start := time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFakeClock(start)
+	defer startRun(clk, p, s, c)()
+
+	// the first poll starts the cooldown
+	poll(t, clk, c, 1)
+	deployment, _ := p.Client.Get(context.Background(), "deploy", metav1.GetOptions{})
+	assert.Equal(t, "100", deployment.Annotations["sqs-autoscaler/last-backlog"])
+	assert.Equal(t, "2020-11-30T12:00:02Z", deployment.Annotations["sqs-autoscaler/cooldown-expiry"])
+	assert.NotContains(t, deployment.Annotations, "sqs-autoscaler/last-desired-replicas")
+
+	poll(t, clk, c, 2)
+	deployment, _ = p.Client.Get(context.Background(), "deploy", metav1.GetOptions{})
+	assert.Equal(t, "5", deployment.Annotations["sqs-autoscaler/last-desired-replicas"])
+	assert.Equal(t, "2020-11-30T12:00:03Z", deployment.Annotations["sqs-autoscaler/last-scale-time"])
+	assert.Equal(t, "pod-1", deployment.Annotations["sqs-autoscaler/instance-id"])
+}
+
+func TestRunRestoresCoolDowns(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	start := time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC)
+	attributes := []map[string]*string{
+		{
+			"ApproximateNumberOfMessages":           aws.String("100"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+	}
+	c := NewScalerConfig(1*time.Second, 10*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
+
+	// a cooldown started long ago by the previous autoscaler already passed
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, 3)
+	p.PersistCoolDowns = true
+	setAnnotation(p, "sqs-autoscaler/cooldown-started", "2020-11-30T11:00:00Z")
+	clk := clock.NewFakeClock(start)
+	stop := startRun(clk, p, NewMockSqsClient(attributes), c)
+	poll(t, clk, c, 1)
+	stop()
+	assert.Equal(t, int32(5), replicas(p))
+
+	// a cooldown that just started keeps running
+	p = NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, 3)
+	p.PersistCoolDowns = true
+	setAnnotation(p, "sqs-autoscaler/cooldown-started", "2020-11-30T11:59:55Z")
+	clk = clock.NewFakeClock(start)
+	defer startRun(clk, p, NewMockSqsClient(attributes), c)()
+	poll(t, clk, c, 5)
+	assert.Equal(t, int32(3), replicas(p))
+	poll(t, clk, c, 1)
+	assert.Equal(t, int32(5), replicas(p))
+
+	// the next cooldown starts on the following poll and is persisted
+	poll(t, clk, c, 1)
+	deployment, _ := p.Client.Get(context.Background(), "deploy", metav1.GetOptions{})
+	assert.Equal(t, "2020-11-30T12:00:07Z", deployment.Annotations["sqs-autoscaler/cooldown-started"])
+}
+
+func TestRunExplainsDecisions(t *testing.T) {
+	decision.Default = decision.NewHistory(decision.DefaultSize)
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 4, 1, 3)
+	p.DryRun = true
+	s := NewMockSqsClient([]map[string]*string{
+		{
+			"ApproximateNumberOfMessages":           aws.String("90"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("5"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("5"),
+		},
+	})
+	c := NewScalerConfig(1*time.Second, 1*time.Second, 20, 4, false, 20*time.Second, "example-queue", "deploy")
+	clk := clock.NewFakeClock(time.Now())
+	defer startRun(clk, p, s, c)()
+
+	poll(t, clk, c, 3)
+	records := decision.Default.Records("namespace/deploy")
+	assert.Len(t, records, 3)
+
+	assert.Equal(t, "cooldown", records[0].Decision)
+	assert.Equal(t, map[string]int{
+		"ApproximateNumberOfMessages":           90,
+		"ApproximateNumberOfMessagesDelayed":    5,
+		"ApproximateNumberOfMessagesNotVisible": 5,
+	}, records[0].Inputs)
+
+	assert.Equal(t, "up", records[2].Decision)
+	assert.Equal(t, "would scale up from 3 to 4 replicas (dry-run)", records[2].Action)
+	assert.Equal(t, []decision.Step{
+		{Name: "coolDown", Detail: "1s passed"},
+		{Name: "messagePerPod", Detail: "ceil(100 messages / 20 per pod) = 5 replicas"},
+		{Name: "min", Detail: "5 is within the min of 1"},
+		{Name: "max", Detail: "5 capped to the max of 4"},
+		{Name: "current", Detail: "3 replicas"},
+		{Name: "dryRun", Detail: "the deployment isn't updated"},
+	}, records[2].Steps)
+}
+
+func TestRunAdminOverrides(t *testing.T) {
+	admin.Default = admin.NewRegistry()
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, 3)
+	s := NewMockSqsClient([]map[string]*string{
+		{
+			"ApproximateNumberOfMessages":           aws.String("100"),
+			"ApproximateNumberOfMessagesDelayed":    aws.String("0"),
+			"ApproximateNumberOfMessagesNotVisible": aws.String("0"),
+		},
+	})
+	c := NewScalerConfig(1*time.Second, 10*time.Second, 20, 100, false, 20*time.Second, "example-queue", "deploy")
+	clk := clock.NewFakeClock(time.Now())
+	target := admin.Register(c.Target())
+	defer startRun(clk, p, s, c)()
+
+	target.Pause(time.Time{})
+	poll(t, clk, c, 15)
+	assert.Equal(t, int32(3), replicas(p))
+
+	// an immediate evaluation skips the cooldown
+	target.Resume()
+	target.EvaluateNow()
+	assert.Eventually(t, func() bool { return replicas(p) == 5 }, time.Second, time.Millisecond)
+
+	target.Pin(2, clk.Now().Add(time.Minute))
+	target.EvaluateNow()
+	assert.Eventually(t, func() bool { return replicas(p) == 2 }, time.Second, time.Millisecond)
+	poll(t, clk, c, 30)
+	assert.Equal(t, int32(2), replicas(p))
+
+	// once the pin expired the backlog counts again
+	poll(t, clk, c, 60)
+	assert.Equal(t, int32(5), replicas(p))
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	kubernetesNamespace = "namespace"
+	p := NewMockPodAutoScaler("deploy", kubernetesNamespace, 100, 1, 3)
+	s := NewMockSqsClient([]map[string]*string{})
+	s.Client.(*MockSQS).Block = true
+	c := NewScalerConfig(time.Second, time.Second, 20, 100, false, time.Second, "example-queue", "deploy")
+	clk := clock.NewFakeClock(time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, clk, p, s, c)
+		close(done)
+	}()
+
+	// wait until the poll is blocked on the SQS call, then cancel it
+	waitForPoll(t, clk)
+	clk.Step(time.Second)
+	assert.Eventually(t, func() bool { return s.Client.(*MockSQS).blocked() }, time.Second, time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the context was cancelled")
+	}
+}
+
+// startRun runs the scaler loop in the background, the returned func stops it
+// and waits until Run returned.
+func startRun(clk clock.Clock, p *scale.PodAutoScaler, s *kubesqs.SqsClient, c *config.ScalerConfig) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Run(ctx, clk, p, s, c)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// poll advances the fake clock by n poll intervals, one at a time, and waits
+// until the loop finished each poll.
+func poll(t *testing.T, clk *clock.FakeClock, c *config.ScalerConfig, n int) {
+	for i := 0; i < n; i++ {
+		waitForPoll(t, clk)
+		clk.Step(c.PollInterval.ToDuration())
+	}
+	waitForPoll(t, clk)
+}
+
+// waitForPoll waits until the loop is waiting for the next poll interval.
+func waitForPoll(t *testing.T, clk *clock.FakeClock) {
+	if !assert.Eventually(t, clk.HasWaiters, time.Second, time.Millisecond, "loop isn't waiting for the next poll") {
+		t.FailNow()
+	}
+}
+
+func setAnnotation(p *scale.PodAutoScaler, key, value string) {
+	deployment, _ := p.Client.Get(context.Background(), p.Deployment, metav1.GetOptions{})
+	deployment.Annotations[key] = value
+	p.Client.Update(context.Background(), deployment, metav1.UpdateOptions{})
+}
+
+func replicas(p *scale.PodAutoScaler) int32 {
+	deployment, _ := p.Client.Get(context.Background(), p.Deployment, metav1.GetOptions{})
+	return *deployment.Spec.Replicas
 }
 
 func NewMockPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace string, max, min, init int) *scale.PodAutoScaler {
@@ -166,10 +605,44 @@ func NewMockPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace s
 type MockSQS struct {
 	QueueAttributes []*sqs.GetQueueAttributesOutput
 	QueueUrl        *sqs.GetQueueUrlOutput
-	cursor          int
+	// Block makes every call wait until its context is cancelled
+	Block     bool
+	Err       error
+	cursor    int
+	mu        sync.Mutex
+	isBlocked bool
+}
+
+func (m *MockSQS) blocked() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isBlocked
+}
+
+func (m *MockSQS) err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Err
 }
 
-func (m *MockSQS) GetQueueAttributes(*sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+// setErr changes Err while a scaler loop runs.
+func (m *MockSQS) setErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Err = err
+}
+
+func (m *MockSQS) GetQueueAttributesWithContext(ctx aws.Context, in *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+	if m.Block {
+		m.mu.Lock()
+		m.isBlocked = true
+		m.mu.Unlock()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if err := m.err(); err != nil {
+		return nil, err
+	}
 	if len(m.QueueAttributes) <= m.cursor {
 		return m.QueueAttributes[m.cursor-1], nil
 	}
@@ -178,7 +651,7 @@ func (m *MockSQS) GetQueueAttributes(*sqs.GetQueueAttributesInput) (*sqs.GetQueu
 	return v, nil
 }
 
-func (m *MockSQS) GetQueueUrl(*sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+func (m *MockSQS) GetQueueUrlWithContext(aws.Context, *sqs.GetQueueUrlInput, ...request.Option) (*sqs.GetQueueUrlOutput, error) {
 	return m.QueueUrl, nil
 }
 
@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "kube_sqs_autoscaler"
+
+var (
+	backlog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_backlog_messages",
+		Help:      "Number of messages in the queue the deployment is scaled on.",
+	}, []string{"namespace", "deployment", "queue"})
+
+	queueAttribute = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_attribute_messages",
+		Help:      "Value of each SQS attribute that makes up the backlog.",
+	}, []string{"namespace", "deployment", "queue", "attribute"})
+
+	desiredReplicas = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "desired_replicas",
+		Help:      "Number of replicas the autoscaler wants for the deployment.",
+	}, []string{"namespace", "deployment", "queue"})
+
+	currentReplicas = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "current_replicas",
+		Help:      "Number of replicas the deployment had on the last evaluation.",
+	}, []string{"namespace", "deployment", "queue"})
+
+	coolDownRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cooldown_remaining_seconds",
+		Help:      "Seconds left until the cooldown period passes.",
+	}, []string{"namespace", "deployment", "queue", "cooldown"})
+
+	conflict = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scaling_conflict",
+		Help:      "1 while another controller seems to manage the replicas of the deployment.",
+	}, []string{"namespace", "deployment", "queue"})
+
+	capacityCapped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "capacity_capped_replicas",
+		Help:      "Number of desired replicas that weren't asked for because pods of the deployment can't be scheduled.",
+	}, []string{"namespace", "deployment", "queue"})
+
+	budgetCapped = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "budget_capped_replicas",
+		Help:      "Number of desired replicas that weren't asked for because of the total or group replica budget.",
+	}, []string{"namespace", "deployment", "queue"})
+
+	projectedSpend = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "projected_hourly_spend",
+		Help:      "Hourly cost of the replicas the deployment has after the last evaluation.",
+	}, []string{"namespace", "deployment", "queue"})
+
+	readFailures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backlog_read_failures",
+		Help:      "Number of polls in a row the backlog couldn't be read on.",
+	}, []string{"namespace", "deployment", "queue"})
+
+	scaleEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scale_events_total",
+		Help:      "Number of scaling decisions by direction and result.",
+	}, []string{"namespace", "deployment", "queue", "direction", "result"})
+
+	sqsLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sqs_request_duration_seconds",
+		Help:      "Latency of SQS API calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace", "deployment", "queue", "operation"})
+
+	attempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_attempts_total",
+		Help:      "Number of SQS and Kubernetes call attempts by outcome, success, retryable or fatal.",
+	}, []string{"namespace", "deployment", "queue", "operation", "outcome"})
+
+	kubernetesLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "kubernetes_request_duration_seconds",
+		Help:      "Latency of Kubernetes API calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace", "deployment", "queue", "operation"})
+)
+
+// Scale directions and results used as scale_events_total labels.
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+	DirectionNone = "none"
+
+	ResultSuccess           = "success"
+	ResultFailure           = "failure"
+	ResultDryRun            = "dry_run"
+	ResultSkipped           = "skipped"
+	ResultPaused            = "paused"
+	ResultConflict          = "conflict"
+	ResultManualOverride    = "manual_override"
+	ResultRolloutInProgress = "rollout_in_progress"
+	ResultPodsPending       = "pods_pending"
+	ResultFrozen            = "frozen"
+)
+
+// Scaler records the metrics of one scaled deployment. A nil *Scaler records
+// nothing, which keeps clients built without metrics working.
+type Scaler struct {
+	namespace  string
+	deployment string
+	queue      string
+}
+
+// NewScaler labels the metrics with the namespace too, as deployments with
+// the same name in different namespaces are different targets.
+func NewScaler(namespace, deployment, queue string) *Scaler {
+	return &Scaler{namespace: namespace, deployment: deployment, queue: queue}
+}
+
+func (s *Scaler) SetBacklog(messages int) {
+	if s == nil {
+		return
+	}
+	backlog.WithLabelValues(s.namespace, s.deployment, s.queue).Set(float64(messages))
+}
+
+func (s *Scaler) SetQueueAttribute(attribute string, messages int) {
+	if s == nil {
+		return
+	}
+	queueAttribute.WithLabelValues(s.namespace, s.deployment, s.queue, attribute).Set(float64(messages))
+}
+
+func (s *Scaler) SetReplicas(current, desired int32) {
+	if s == nil {
+		return
+	}
+	currentReplicas.WithLabelValues(s.namespace, s.deployment, s.queue).Set(float64(current))
+	desiredReplicas.WithLabelValues(s.namespace, s.deployment, s.queue).Set(float64(desired))
+}
+
+func (s *Scaler) SetCoolDownRemaining(cooldown string, remaining time.Duration) {
+	if s == nil {
+		return
+	}
+	coolDownRemaining.WithLabelValues(s.namespace, s.deployment, s.queue, cooldown).Set(remaining.Seconds())
+}
+
+func (s *Scaler) SetConflict(conflicting bool) {
+	if s == nil {
+		return
+	}
+	value := 0.0
+	if conflicting {
+		value = 1
+	}
+	conflict.WithLabelValues(s.namespace, s.deployment, s.queue).Set(value)
+}
+
+func (s *Scaler) SetCapacityCapped(replicas int32) {
+	if s == nil {
+		return
+	}
+	capacityCapped.WithLabelValues(s.namespace, s.deployment, s.queue).Set(float64(replicas))
+}
+
+func (s *Scaler) SetBudgetCapped(replicas int32) {
+	if s == nil {
+		return
+	}
+	budgetCapped.WithLabelValues(s.namespace, s.deployment, s.queue).Set(float64(replicas))
+}
+
+func (s *Scaler) SetProjectedHourlySpend(spend float64) {
+	if s == nil {
+		return
+	}
+	projectedSpend.WithLabelValues(s.namespace, s.deployment, s.queue).Set(spend)
+}
+
+func (s *Scaler) SetReadFailures(failures int) {
+	if s == nil {
+		return
+	}
+	readFailures.WithLabelValues(s.namespace, s.deployment, s.queue).Set(float64(failures))
+}
+
+func (s *Scaler) ScaleEvent(direction, result string) {
+	if s == nil {
+		return
+	}
+	scaleEvents.WithLabelValues(s.namespace, s.deployment, s.queue, direction, result).Inc()
+}
+
+// ObserveSQS records the latency of an SQS call started at start.
+func (s *Scaler) ObserveSQS(operation string, start time.Time) {
+	if s == nil {
+		return
+	}
+	sqsLatency.WithLabelValues(s.namespace, s.deployment, s.queue, operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveKubernetes records the latency of a Kubernetes call started at start.
+func (s *Scaler) ObserveKubernetes(operation string, start time.Time) {
+	if s == nil {
+		return
+	}
+	kubernetesLatency.WithLabelValues(s.namespace, s.deployment, s.queue, operation).Observe(time.Since(start).Seconds())
+}
+
+// Attempts returns a func counting the attempts of the operation by outcome,
+// see retry.Policy.Do.
+func (s *Scaler) Attempts(operation string) func(outcome string) {
+	return func(outcome string) {
+		if s == nil {
+			return
+		}
+		attempts.WithLabelValues(s.namespace, s.deployment, s.queue, operation, outcome).Inc()
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScalerMetrics(t *testing.T) {
+	s := NewScaler("namespace", "deploy", "queue")
+	s.SetBacklog(42)
+	s.SetQueueAttribute("ApproximateNumberOfMessages", 40)
+	s.SetReplicas(3, 5)
+	s.SetCoolDownRemaining("scaling", 90*time.Second)
+	s.ScaleEvent(DirectionUp, ResultSuccess)
+	s.ScaleEvent(DirectionUp, ResultSuccess)
+	s.ObserveSQS("GetQueueAttributes", time.Now())
+	s.SetConflict(true)
+	s.SetReadFailures(2)
+	s.SetCapacityCapped(4)
+	s.SetBudgetCapped(6)
+	s.SetProjectedHourlySpend(1.5)
+	s.Attempts("GetQueueAttributes")("retryable")
+
+	assert.Equal(t, float64(42), testutil.ToFloat64(backlog.WithLabelValues("namespace", "deploy", "queue")))
+	assert.Equal(t, float64(40), testutil.ToFloat64(queueAttribute.WithLabelValues("namespace", "deploy", "queue", "ApproximateNumberOfMessages")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(currentReplicas.WithLabelValues("namespace", "deploy", "queue")))
+	assert.Equal(t, float64(5), testutil.ToFloat64(desiredReplicas.WithLabelValues("namespace", "deploy", "queue")))
+	assert.Equal(t, float64(90), testutil.ToFloat64(coolDownRemaining.WithLabelValues("namespace", "deploy", "queue", "scaling")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(scaleEvents.WithLabelValues("namespace", "deploy", "queue", DirectionUp, ResultSuccess)))
+	assert.Equal(t, 1, testutil.CollectAndCount(sqsLatency))
+	assert.Equal(t, float64(1), testutil.ToFloat64(conflict.WithLabelValues("namespace", "deploy", "queue")))
+	assert.Equal(t, 1.5, testutil.ToFloat64(projectedSpend.WithLabelValues("namespace", "deploy", "queue")))
+	assert.Equal(t, float64(6), testutil.ToFloat64(budgetCapped.WithLabelValues("namespace", "deploy", "queue")))
+	assert.Equal(t, float64(4), testutil.ToFloat64(capacityCapped.WithLabelValues("namespace", "deploy", "queue")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(readFailures.WithLabelValues("namespace", "deploy", "queue")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(attempts.WithLabelValues("namespace", "deploy", "queue", "GetQueueAttributes", "retryable")))
+}
+
+func TestNilScalerRecordsNothing(t *testing.T) {
+	var s *Scaler
+	assert.NotPanics(t, func() {
+		s.SetBacklog(1)
+		s.SetReplicas(1, 2)
+		s.ScaleEvent(DirectionDown, ResultFailure)
+		s.ObserveKubernetes("GetDeployment", time.Now())
+		s.Attempts("GetDeployment")("success")
+	})
+}
+
+func TestScalerMetricsByNamespace(t *testing.T) {
+	NewScaler("a", "same", "queue").SetBacklog(1)
+	NewScaler("b", "same", "queue").SetBacklog(2)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(backlog.WithLabelValues("a", "same", "queue")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(backlog.WithLabelValues("b", "same", "queue")))
+}
@@ -0,0 +1,84 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// Defaults of the retry policy flags.
+const (
+	DefaultMaxAttempts = 3
+	DefaultBaseDelay   = 200 * time.Millisecond
+	DefaultMaxDelay    = 2 * time.Second
+	DefaultJitter      = 0.2
+)
+
+// Outcomes of an attempt passed to the observer of Do.
+const (
+	OutcomeSuccess   = "success"
+	OutcomeRetryable = "retryable"
+	OutcomeFatal     = "fatal"
+)
+
+// Policy retries failed calls with exponential backoff. The delay before the
+// nth retry is BaseDelay * 2^(n-1), capped at MaxDelay and shortened by up to
+// Jitter (a fraction between 0 and 1) at random so loops failing together
+// don't retry in lockstep. The zero Policy makes a single attempt.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+	// Clock waits between the attempts, the wall clock without one.
+	Clock clock.Clock
+}
+
+// Do calls f until it succeeds, fails with an error retryable reports as
+// fatal, the attempts are used up or ctx is done. observe is called with the
+// outcome of every attempt. The error of the last attempt is returned.
+func (p Policy) Do(ctx context.Context, retryable func(error) bool, observe func(outcome string), f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			observe(OutcomeSuccess)
+			return nil
+		}
+		if !retryable(err) {
+			observe(OutcomeFatal)
+			return err
+		}
+		observe(OutcomeRetryable)
+		if attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		timer := p.clock().NewTimer(p.delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C():
+		}
+	}
+}
+
+func (p Policy) clock() clock.Clock {
+	if p.Clock == nil {
+		return clock.RealClock{}
+	}
+	return p.Clock
+}
+
+// delay returns how long to wait before the given retry.
+func (p Policy) delay(retry int) time.Duration {
+	d := float64(p.BaseDelay) * math.Pow(2, float64(retry-1))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	return time.Duration(d * (1 - jitter*rand.Float64()))
+}
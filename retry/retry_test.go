@@ -0,0 +1,120 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+var (
+	errRetryable = errors.New("throttled")
+	errFatal     = errors.New("forbidden")
+)
+
+func isRetryable(err error) bool {
+	return err == errRetryable
+}
+
+func TestDoRetriesUntilSuccess(t *testing.T) {
+	p := Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	outcomes := []string{}
+	calls := 0
+	err := p.Do(context.Background(), isRetryable, func(o string) { outcomes = append(outcomes, o) }, func() error {
+		calls++
+		if calls < 3 {
+			return errRetryable
+		}
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{OutcomeRetryable, OutcomeRetryable, OutcomeSuccess}, outcomes)
+}
+
+func TestDoGivesUp(t *testing.T) {
+	p := Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	outcomes := []string{}
+	err := p.Do(context.Background(), isRetryable, func(o string) { outcomes = append(outcomes, o) }, func() error {
+		return errRetryable
+	})
+	assert.Equal(t, errRetryable, err)
+	assert.Len(t, outcomes, 3)
+
+	outcomes = []string{}
+	err = p.Do(context.Background(), isRetryable, func(o string) { outcomes = append(outcomes, o) }, func() error {
+		return errFatal
+	})
+	assert.Equal(t, errFatal, err)
+	assert.Equal(t, []string{OutcomeFatal}, outcomes, "fatal errors aren't retried")
+}
+
+func TestDoBacksOff(t *testing.T) {
+	clk := clock.NewFakeClock(time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC))
+	p := Policy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Clock: clk}
+	calls := make(chan time.Time, 3)
+	done := make(chan error)
+	go func() {
+		done <- p.Do(context.Background(), isRetryable, func(string) {}, func() error {
+			calls <- clk.Now()
+			return errRetryable
+		})
+	}()
+
+	start := <-calls
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second} {
+		for !clk.HasWaiters() {
+			time.Sleep(time.Millisecond)
+		}
+		clk.Step(delay - time.Millisecond)
+		assert.Empty(t, calls, "retried before the backoff")
+		clk.Step(time.Millisecond)
+		assert.Equal(t, delay, (<-calls).Sub(start))
+		start = clk.Now()
+	}
+	assert.Equal(t, errRetryable, <-done)
+}
+
+func TestDoStopsOnCancel(t *testing.T) {
+	p := Policy{MaxAttempts: 5, BaseDelay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	err := p.Do(ctx, isRetryable, func(string) {}, func() error {
+		calls++
+		return errRetryable
+	})
+	assert.Equal(t, errRetryable, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestZeroPolicyMakesOneAttempt(t *testing.T) {
+	calls := 0
+	Policy{}.Do(context.Background(), isRetryable, func(string) {}, func() error {
+		calls++
+		return errRetryable
+	})
+	assert.Equal(t, 1, calls)
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(t, 100*time.Millisecond, p.delay(1))
+	assert.Equal(t, 200*time.Millisecond, p.delay(2))
+	assert.Equal(t, 800*time.Millisecond, p.delay(4))
+	assert.Equal(t, time.Second, p.delay(5))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.delay(5)
+		assert.True(t, d > 500*time.Millisecond && d <= time.Second, d)
+	}
+}
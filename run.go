@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"kube-sqs-autoscaler/admin"
+	"kube-sqs-autoscaler/config"
+	"kube-sqs-autoscaler/decision"
+	"kube-sqs-autoscaler/health"
+	"kube-sqs-autoscaler/scale"
+	kubesqs "kube-sqs-autoscaler/sqs"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+type ScalingTimeDiff struct {
+	t              *time.Time
+	CoolDownPeriod config.Duration
+	Clock          clock.PassiveClock
+}
+
+func (s *ScalingTimeDiff) CoolDownPassed() bool {
+	if s.t == nil {
+		t := s.Clock.Now()
+		s.t = &t
+		return false
+	}
+
+	return s.t.Add(s.CoolDownPeriod.ToDuration()).Before(s.Clock.Now())
+}
+
+// Remaining returns how long until the cooldown passes. The full period is
+// left while the timer hasn't started.
+func (s *ScalingTimeDiff) Remaining() time.Duration {
+	if s.t == nil {
+		return s.CoolDownPeriod.ToDuration()
+	}
+
+	remaining := s.t.Add(s.CoolDownPeriod.ToDuration()).Sub(s.Clock.Now())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Expiry returns when the cooldown passes, or nil while the timer hasn't
+// started.
+func (s *ScalingTimeDiff) Expiry() *time.Time {
+	if s.t == nil {
+		return nil
+	}
+	expiry := s.t.Add(s.CoolDownPeriod.ToDuration())
+	return &expiry
+}
+
+func (s *ScalingTimeDiff) Reset() {
+	s.t = nil
+}
+
+// loopState is what a scaler loop remembers between polls.
+type loopState struct {
+	lastScalingTime *ScalingTimeDiff
+	zeroScalingTime *ScalingTimeDiff
+	lastScaleTime   *time.Time
+	lastDesired     *int32
+	queue           string
+	admin           *admin.Target
+
+	// failures counts the polls in a row the backlog couldn't be read on,
+	// lastRead is when it was last read and failSafe tells if the
+	// onMetricFailure policy is applied.
+	onMetricFailure *config.MetricFailure
+	failures        int
+	lastRead        time.Time
+	failSafe        bool
+}
+
+// Run polls the queue and scales the deployment until ctx is cancelled. All
+// waiting is done on clk so tests can advance time instantly.
+func Run(ctx context.Context, clk clock.Clock, p *scale.PodAutoScaler, sqs *kubesqs.SqsClient, cfg *config.ScalerConfig) {
+	state := &loopState{
+		lastScalingTime: &ScalingTimeDiff{CoolDownPeriod: cfg.CoolDownPeriod, Clock: clk},
+		zeroScalingTime: &ScalingTimeDiff{CoolDownPeriod: cfg.ZeroScalingCoolDown, Clock: clk},
+		queue:           cfg.QueueIdentifier(),
+		admin:           admin.Register(cfg.Target()),
+		onMetricFailure: cfg.OnMetricFailure,
+		lastRead:        clk.Now(),
+	}
+	if p.Clock == nil {
+		p.Clock = clk
+	}
+	if p.Retry.Clock == nil {
+		p.Retry.Clock = clk
+	}
+	if sqs.Retry.Clock == nil {
+		sqs.Retry.Clock = clk
+	}
+	if p.PersistCoolDowns {
+		restoreCoolDowns(ctx, p, state)
+	}
+	// the first evaluation waits for the cooldown, but the other deployments
+	// sharing a budget need the replicas before
+	if err := p.ReportReplicas(ctx); err != nil {
+		p.Logger().Warnf("[autoscaler] Failed to report the replicas to the budgets: %v", err)
+	}
+
+	pollInterval := cfg.PollInterval.ToDuration()
+	h := health.Register(cfg.Target(), pollInterval)
+	defer func() {
+		if r := recover(); r != nil {
+			p.Logger().Errorf("[autoscaler] Scaler loop panicked: %v\n%s", r, debug.Stack())
+			h.Stopped(fmt.Sprintf("panic: %v", r))
+			return
+		}
+		if ctx.Err() != nil {
+			// stopped on purpose, the loop isn't dead
+			health.Deregister(cfg.Target())
+			return
+		}
+		h.Stopped("loop exited")
+	}()
+
+	for {
+		force := false
+		timer := clk.NewTimer(pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		case <-state.admin.Evaluations():
+			timer.Stop()
+			force = true
+		}
+		err := evaluate(ctx, clk, p, sqs, state, force)
+		if ctx.Err() != nil {
+			return
+		}
+		h.Polled(err)
+	}
+}
+
+// evaluate reads the backlog once and scales if the cooldowns and the admin
+// overrides allow it. force skips the cooldowns. Waiting for a cooldown isn't
+// an error. Every evaluation is explained in a decision record.
+func evaluate(ctx context.Context, clk clock.PassiveClock, p *scale.PodAutoScaler, sqs *kubesqs.SqsClient, state *loopState, force bool) error {
+	lastScalingTime, zeroScalingTime := state.lastScalingTime, state.zeroScalingTime
+	explain := &decision.Record{
+		Time:       clk.Now(),
+		Deployment: p.Deployment,
+		Namespace:  p.Namespace,
+		Queue:      state.queue,
+	}
+	defer recordDecision(p, explain)
+
+	backlog, err := sqs.ReadBacklog(ctx)
+	if err != nil {
+		p.Logger().WithField("decision", scale.DecisionError).Errorf("[autoscaler] Failed to get SQS messages: %v", err)
+		if ctx.Err() == nil {
+			p.Eventf(corev1.EventTypeWarning, scale.ReasonQueueUnreachable, "Failed to get the number of messages in the queue: %v", err)
+		}
+		explain.Decision, explain.Action, explain.Error = scale.DecisionError, "none, the queue couldn't be read", err.Error()
+		if ctx.Err() == nil {
+			onMetricFailure(ctx, clk, p, state, explain)
+		}
+		return err
+	}
+	if state.failSafe {
+		p.Logger().Info("[autoscaler] The backlog can be read again, scaling on it")
+	}
+	state.failures, state.lastRead, state.failSafe = 0, clk.Now(), false
+	p.Metrics.SetReadFailures(0)
+	numMessages := backlog.Messages
+	explain.Inputs, explain.Backlog = backlog.Attributes, numMessages
+	defer writeStatus(ctx, p, state, numMessages)
+
+	logger := p.Logger().WithField("backlog", numMessages)
+
+	override := state.admin.Override(clk.Now())
+	if override.Paused {
+		logger.WithField("decision", scale.DecisionPaused).Info("[autoscaler] Scaling is paused")
+		explain.Step("admin", "paused")
+		explain.Decision, explain.Action = scale.DecisionPaused, "none, scaling is paused"
+		return nil
+	}
+	if override.Replicas != nil {
+		explain.Step("admin", "pinned to %d replicas until %s", *override.Replicas, override.PinnedUntil.UTC().Format(time.RFC3339))
+		return applyResult(clk, p, state, explain, p.ScaleTo(ctx, numMessages, *override.Replicas))
+	}
+
+	zeroCoolDownPassed, coolDownPassed := true, true
+	if force {
+		explain.Step("admin", "immediate evaluation, cooldowns are skipped")
+	} else {
+		zeroCoolDownPassed = numMessages > 0 || zeroScalingTime.CoolDownPassed()
+		coolDownPassed = numMessages == 0 || lastScalingTime.CoolDownPassed()
+		if numMessages == 0 {
+			explain.Step("zeroScalingCoolDown", coolDownDetail(zeroScalingTime, zeroCoolDownPassed))
+		} else {
+			explain.Step("coolDown", coolDownDetail(lastScalingTime, coolDownPassed))
+		}
+	}
+	p.Metrics.SetCoolDownRemaining("scaling", lastScalingTime.Remaining())
+	p.Metrics.SetCoolDownRemaining("zero_scaling", zeroScalingTime.Remaining())
+
+	if !zeroCoolDownPassed {
+		logger.WithField("decision", scale.DecisionZeroScalingCoolDown).Info("[autoscaler] Have 0 messages but waiting for cooldown period")
+		explain.Decision, explain.Action = scale.DecisionZeroScalingCoolDown, "wait for the zero scaling cooldown"
+		return nil
+	}
+
+	if !coolDownPassed {
+		logger.WithField("decision", scale.DecisionCoolDown).Info("[autoscaler] Waiting for cooldown period to pass")
+		explain.Decision, explain.Action = scale.DecisionCoolDown, "wait for the cooldown"
+		return nil
+	}
+	return applyResult(clk, p, state, explain, p.Scale(ctx, numMessages))
+}
+
+// onMetricFailure applies the onMetricFailure policy once the backlog
+// couldn't be read often or long enough. The replicas are held without a
+// policy and while an admin override is active.
+func onMetricFailure(ctx context.Context, clk clock.PassiveClock, p *scale.PodAutoScaler, state *loopState, explain *decision.Record) {
+	state.failures++
+	p.Metrics.SetReadFailures(state.failures)
+	policy := state.onMetricFailure
+	if policy == nil {
+		return
+	}
+
+	age := clk.Since(state.lastRead).Round(time.Second)
+	stale := policy.StaleAfter > 0 && age >= policy.StaleAfter.ToDuration()
+	detail := fmt.Sprintf("%d reads in a row failed, the last reading is %s old", state.failures, age)
+	if state.failures < policy.After && !stale {
+		explain.Step("onMetricFailure", "%s, the %s policy applies after %d", detail, policy.Policy, policy.After)
+		return
+	}
+	if !state.failSafe {
+		state.failSafe = true
+		p.Logger().WithField("policy", policy.Policy).Warnf("[autoscaler] %s, applying the onMetricFailure policy", detail)
+		p.Eventf(corev1.EventTypeWarning, scale.ReasonMetricFailure, "The backlog couldn't be read, %s, applying the %s policy", detail, policy.Policy)
+	}
+
+	if override := state.admin.Override(clk.Now()); override.Paused || override.Replicas != nil {
+		explain.Step("onMetricFailure", "%s, the %s policy is overridden by the admin API", detail, policy.Policy)
+		return
+	}
+	var replicas int32
+	switch policy.Policy {
+	case config.MetricFailureFallback:
+		replicas = int32(policy.Replicas)
+	case config.MetricFailureMax:
+		replicas = int32(p.Max)
+	default:
+		explain.Step("onMetricFailure", "%s, holding the replicas", detail)
+		explain.Action = "keep the replicas, the queue couldn't be read"
+		return
+	}
+	readErr := explain.Error
+	applyResult(clk, p, state, explain, p.FailSafe(ctx, replicas, detail))
+	if explain.Error == "" {
+		explain.Error = readErr
+	}
+}
+
+// applyResult explains the scaling result and restarts the cooldowns when the
+// replicas changed.
+func applyResult(clk clock.PassiveClock, p *scale.PodAutoScaler, state *loopState, explain *decision.Record, scalingResult *scale.ScalingResult) error {
+	explain.Steps = append(explain.Steps, scalingResult.Steps...)
+	explain.Decision, explain.Action = scalingResult.Decision(), scalingResult.Action(p.DryRun)
+	if scalingResult.Err != nil {
+		// Scale logged the failure
+		explain.Error = scalingResult.Err.Error()
+		return scalingResult.Err
+	}
+	desired := scalingResult.DesiredReplicas
+	state.lastDesired = &desired
+
+	replicas := scalingResult.CurrentReplicas
+	if !scalingResult.ScalingSkipped {
+		replicas = desired
+	}
+	if spend, ok := p.Budget.HourlySpend(replicas); ok {
+		p.Metrics.SetProjectedHourlySpend(spend)
+	}
+
+	if !scalingResult.ScalingSkipped {
+		now := clk.Now()
+		state.lastScaleTime = &now
+		state.lastScalingTime.Reset()
+		state.zeroScalingTime.Reset()
+	}
+	return nil
+}
+
+func coolDownDetail(s *ScalingTimeDiff, passed bool) string {
+	if passed {
+		return fmt.Sprintf("%s passed", s.CoolDownPeriod.ToDuration())
+	}
+	return fmt.Sprintf("%s left of %s", s.Remaining(), s.CoolDownPeriod.ToDuration())
+}
+
+// recordDecision keeps the record for /debug/decisions and logs it at debug
+// level.
+func recordDecision(p *scale.PodAutoScaler, explain *decision.Record) {
+	decision.Add(*explain)
+	p.Logger().WithField("decision", explain.Decision).Debugf("[autoscaler] Evaluated scaling: %s", explain)
+}
+
+// writeStatus annotates the deployment with the outcome of the poll, see
+// --status-annotations.
+func writeStatus(ctx context.Context, p *scale.PodAutoScaler, state *loopState, numMessages int) {
+	coolDown := state.lastScalingTime
+	if numMessages == 0 {
+		coolDown = state.zeroScalingTime
+	}
+
+	err := p.WriteStatus(ctx, scale.Status{
+		Backlog:         numMessages,
+		DesiredReplicas: state.lastDesired,
+		LastScaleTime:   state.lastScaleTime,
+		CoolDownExpiry:  coolDown.Expiry(),
+
+		CoolDownStarted:            state.lastScalingTime.t,
+		ZeroScalingCoolDownStarted: state.zeroScalingTime.t,
+	})
+	if err != nil {
+		p.Logger().Errorf("[autoscaler] %v", err)
+	}
+}
+
+// restoreCoolDowns picks up the cooldown timers a previous autoscaler left on
+// the deployment, see --persist-cooldowns. The timers start fresh if they
+// can't be read.
+func restoreCoolDowns(ctx context.Context, p *scale.PodAutoScaler, state *loopState) {
+	coolDownStarted, zeroScalingCoolDownStarted, err := p.RestoreCoolDowns(ctx)
+	if err != nil {
+		p.Logger().Errorf("[autoscaler] Failed to restore cooldowns: %v", err)
+		return
+	}
+	state.lastScalingTime.t = coolDownStarted
+	state.zeroScalingTime.t = zeroScalingCoolDownStarted
+	if coolDownStarted != nil || zeroScalingCoolDownStarted != nil {
+		p.Logger().Info("[autoscaler] Restored cooldowns")
+	}
+}
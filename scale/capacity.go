@@ -0,0 +1,81 @@
+package scale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// capacityCap returns the most replicas the deployment should ask for while
+// some of its pods can't be scheduled: the pods that could be scheduled plus
+// CapacityHeadroom, but never less than current. ok is false when no pod is
+// unschedulable or the pods can't be listed, e.g. without permissions.
+func (p *PodAutoScaler) capacityCap(ctx context.Context, deployment *appsv1.Deployment, current int32) (limit int32, unschedulable int, ok bool) {
+	if p.Pods == nil || deployment.Spec.Selector == nil {
+		return 0, 0, false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		p.Logger().Warnf("[autoscaler] Invalid pod selector, not checking for unschedulable pods: %v", err)
+		return 0, 0, false
+	}
+	start := time.Now()
+	pods, err := p.Pods.List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	p.Metrics.ObserveKubernetes("ListPods", start)
+	if err != nil {
+		p.Logger().Warnf("[autoscaler] Failed to list pods, not checking for unschedulable pods: %v", err)
+		return 0, 0, false
+	}
+
+	schedulable := 0
+	for _, pod := range pods.Items {
+		switch {
+		case pod.DeletionTimestamp != nil, pod.Status.Phase == corev1.PodSucceeded, pod.Status.Phase == corev1.PodFailed:
+		case isUnschedulable(&pod):
+			unschedulable++
+		default:
+			schedulable++
+		}
+	}
+	if unschedulable == 0 {
+		return 0, 0, false
+	}
+	limit = int32(schedulable + p.CapacityHeadroom)
+	if limit < current {
+		limit = current
+	}
+	return limit, unschedulable, true
+}
+
+// isUnschedulable tells if the scheduler found no node for the pod.
+func isUnschedulable(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled {
+			return c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable
+		}
+	}
+	return false
+}
+
+// capAtCapacity lowers desired replicas above current to the capacity cap and
+// explains it.
+func (p *PodAutoScaler) capAtCapacity(ctx context.Context, deployment *appsv1.Deployment, current, desired int32) (int32, string) {
+	if desired <= current {
+		p.Metrics.SetCapacityCapped(0)
+		return desired, ""
+	}
+	limit, unschedulable, ok := p.capacityCap(ctx, deployment, current)
+	if !ok || desired <= limit {
+		p.Metrics.SetCapacityCapped(0)
+		return desired, ""
+	}
+	p.Metrics.SetCapacityCapped(desired - limit)
+	detail := fmt.Sprintf("%d pods are unschedulable, %d capped to %d replicas with a headroom of %d", unschedulable, desired, limit, p.CapacityHeadroom)
+	p.Logger().WithField("unschedulable", unschedulable).Warnf("[autoscaler] %s", detail)
+	p.Eventf(corev1.EventTypeWarning, ReasonCapacityCapped, "%d pods are unschedulable, capping %d desired replicas at %d", unschedulable, desired, limit)
+	return limit, detail
+}
@@ -0,0 +1,118 @@
+package scale
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"kube-sqs-autoscaler/config"
+	"kube-sqs-autoscaler/decision"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestScaleCapsAtSchedulablePods(t *testing.T) {
+	// 3 pods run, 2 more are unschedulable
+	p := NewMockPodAutoScaler("deploy", "namespace", 20, 1, 5, capacityPods(3, 2)...)
+	p.CapacityAware = true
+	p.CapacityHeadroom = 1
+	recorder := record.NewFakeRecorder(10)
+	p.Recorder = recorder
+
+	res := p.Scale(context.Background(), 200)
+	assert.Equal(t, int32(5), res.CurrentReplicas)
+	assert.Equal(t, int32(5), res.DesiredReplicas)
+	assert.True(t, res.ScalingSkipped)
+	assert.Contains(t, res.Steps, decision.NewStep("capacity", "2 pods are unschedulable, 10 capped to 5 replicas with a headroom of 1"))
+	assert.Equal(t, "Warning CapacityCapped 2 pods are unschedulable, capping 10 desired replicas at 5", <-recorder.Events)
+	assert.Equal(t, int32(5), replicasOf(p))
+}
+
+func TestScaleCapsWithHeadroom(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 20, 1, 5, capacityPods(3, 2)...)
+	p.CapacityAware = true
+	p.CapacityHeadroom = 4
+
+	res := p.Scale(context.Background(), 200)
+	assert.Equal(t, int32(7), res.DesiredReplicas)
+	assert.Equal(t, int32(7), replicasOf(p))
+}
+
+func TestScaleWithoutUnschedulablePods(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 20, 1, 5, capacityPods(5, 0)...)
+	p.CapacityAware = true
+
+	res := p.Scale(context.Background(), 200)
+	assert.Equal(t, int32(10), res.DesiredReplicas)
+	assert.Equal(t, int32(10), replicasOf(p))
+}
+
+func TestScaleDownIgnoresUnschedulablePods(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 20, 1, 5, capacityPods(3, 2)...)
+	p.CapacityAware = true
+
+	res := p.Scale(context.Background(), 20)
+	assert.Equal(t, int32(1), res.DesiredReplicas)
+	assert.Equal(t, int32(1), replicasOf(p))
+}
+
+func TestScaleSkipsCapacityWhenPausedOrFrozen(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 20, 1, 5, capacityPods(3, 2)...)
+	p.CapacityAware = true
+	recorder := record.NewFakeRecorder(10)
+	p.Recorder = recorder
+
+	annotate(p, map[string]string{AnnotationPaused: "true"})
+	res := p.Scale(ctx, 200)
+	assert.Equal(t, ReasonPaused, res.Reason)
+	assert.Len(t, recorder.Events, 0, "no CapacityCapped events")
+
+	annotate(p, nil)
+	start, end := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	p.FreezeWindows = []config.FreezeWindow{{Mode: config.FreezeNoScaling, Start: &start, End: &end}}
+	res = p.Scale(ctx, 200)
+	assert.Equal(t, ReasonFrozen, res.Reason)
+	assert.Len(t, recorder.Events, 0, "no CapacityCapped events")
+}
+
+func TestIsUnschedulable(t *testing.T) {
+	pending := &corev1.Pod{Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+		{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable},
+	}}}
+	scheduled := &corev1.Pod{Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+		{Type: corev1.PodScheduled, Status: corev1.ConditionTrue},
+	}}}
+	assert.True(t, isUnschedulable(pending))
+	assert.False(t, isUnschedulable(scheduled))
+	assert.False(t, isUnschedulable(&corev1.Pod{}))
+}
+
+// capacityPods returns the pods of the deployment deploy, of which running
+// pods were scheduled and unschedulable pods weren't, and a pod of another
+// deployment.
+func capacityPods(running, unschedulable int) []runtime.Object {
+	labels := map[string]string{"app": "deploy"}
+	objects := []runtime.Object{}
+	for i := 0; i < running+unschedulable; i++ {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("deploy-%d", i), Namespace: "namespace", Labels: labels},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		if i >= running {
+			pod.Status.Phase = corev1.PodPending
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable}}
+		}
+		objects = append(objects, pod)
+	}
+	return append(objects, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "namespace", Labels: map[string]string{"app": "other"}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	})
+}
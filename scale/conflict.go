@@ -0,0 +1,78 @@
+package scale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FieldManager is the manager the autoscaler writes deployments as.
+const FieldManager = "kube-sqs-autoscaler"
+
+// DefaultConflictWindow is how long after another manager wrote the replicas
+// the deployment counts as managed by it.
+const DefaultConflictWindow = 10 * time.Minute
+
+// conflict returns why another controller seems to manage the replicas of the
+// deployment, or "" when the autoscaler is on its own. The managed fields only
+// count when the replicas differ from the ones the autoscaler last saw or
+// wrote, as managers owning spec.replicas since they created the deployment
+// also write it with every other change, e.g. a new image. Replicas changed
+// by hand before a manual override hold started are left to the hold.
+func (p *PodAutoScaler) conflict(ctx context.Context, deployment *appsv1.Deployment, now time.Time) string {
+	if reason := p.hpaConflict(ctx); reason != "" {
+		return reason
+	}
+	if p.ConflictWindow > 0 && p.knownReplicas != nil && *p.knownReplicas != *deployment.Spec.Replicas {
+		since := now.Add(-p.ConflictWindow)
+		if p.ManualOverrideHold > 0 && since.Before(p.overriddenAt) {
+			since = p.overriddenAt
+		}
+		return replicasManagedBy(deployment, since)
+	}
+	return ""
+}
+
+// hpaConflict looks for a HorizontalPodAutoscaler scaling the deployment. The
+// check is skipped when the HPAs can't be listed, e.g. without permissions.
+func (p *PodAutoScaler) hpaConflict(ctx context.Context) string {
+	if p.HPAs == nil {
+		return ""
+	}
+	start := time.Now()
+	hpas, err := p.HPAs.List(ctx, metav1.ListOptions{})
+	p.Metrics.ObserveKubernetes("ListHorizontalPodAutoscalers", start)
+	if err != nil {
+		p.Logger().Warnf("[autoscaler] Failed to list HorizontalPodAutoscalers, not checking for conflicts: %v", err)
+		return ""
+	}
+	for _, hpa := range hpas.Items {
+		ref := hpa.Spec.ScaleTargetRef
+		if ref.Kind == "Deployment" && ref.Name == p.Deployment {
+			return fmt.Sprintf("HorizontalPodAutoscaler %s scales the deployment", hpa.Name)
+		}
+	}
+	return ""
+}
+
+// replicasManagedBy returns which other manager wrote spec.replicas after the
+// given time according to the managed fields.
+func replicasManagedBy(deployment *appsv1.Deployment, since time.Time) string {
+	for _, entry := range deployment.ManagedFields {
+		if entry.Manager == FieldManager || entry.Time == nil || !entry.Time.Time.After(since) || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, ok := fields["f:spec"]["f:replicas"]; ok {
+			return fmt.Sprintf("%s changed the replicas at %s", entry.Manager, entry.Time.UTC().Format(time.RFC3339))
+		}
+	}
+	return ""
+}
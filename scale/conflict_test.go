@@ -0,0 +1,117 @@
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestScaleRefusesHPAConflict(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3, &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "deploy-hpa", Namespace: "namespace"},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{Kind: "Deployment", Name: "deploy", APIVersion: "apps/v1"},
+		},
+	})
+	recorder := record.NewFakeRecorder(10)
+	p.Recorder = recorder
+
+	res := p.Scale(ctx, 75)
+	assert.Nil(t, res.Err)
+	assert.True(t, res.ScalingSkipped)
+	assert.Equal(t, ReasonScalingConflict, res.Reason)
+	assert.Equal(t, DecisionConflict, res.Decision())
+	assert.Equal(t, "Warning ScalingConflict Not scaling from 3 to 4 replicas, HorizontalPodAutoscaler deploy-hpa scales the deployment", <-recorder.Events)
+	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+
+	p.IgnoreConflicts = true
+	res = p.Scale(ctx, 75)
+	assert.Equal(t, "", res.Reason)
+	deployment, _ = p.Client.Get(ctx, "deploy", metav1.GetOptions{})
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas)
+}
+
+func TestScaleIgnoresHPAOfOtherTargets(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3, &autoscalingv1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-hpa", Namespace: "namespace"},
+		Spec: autoscalingv1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv1.CrossVersionObjectReference{Kind: "Deployment", Name: "other", APIVersion: "apps/v1"},
+		},
+	})
+
+	res := p.Scale(context.Background(), 75)
+	assert.Equal(t, "", res.Reason)
+	assert.False(t, res.ScalingSkipped)
+}
+
+func TestScaleIgnoresManagerOfUnchangedReplicas(t *testing.T) {
+	ctx := context.Background()
+	// helm created the deployment and owns spec.replicas since
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	deployment, _ := p.Client.Get(ctx, p.Deployment, metav1.GetOptions{})
+	deployment.ManagedFields = []metav1.ManagedFieldsEntry{replicasEntry("helm", time.Now())}
+	p.Client.Update(ctx, deployment, metav1.UpdateOptions{})
+	res := p.Scale(ctx, 75)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(4), replicasOf(p))
+
+	// an image only upgrade moves the time of helm's entry
+	deployment, _ = p.Client.Get(ctx, p.Deployment, metav1.GetOptions{})
+	deployment.ManagedFields = []metav1.ManagedFieldsEntry{replicasEntry("helm", time.Now())}
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{{Name: "worker", Image: "worker:2"}}
+	p.Client.Update(ctx, deployment, metav1.UpdateOptions{})
+	res = p.Scale(ctx, 100)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(5), replicasOf(p))
+}
+
+func TestScaleRefusesReplicasChangedByOtherManager(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.Scale(ctx, 75)
+
+	deployment, _ := p.Client.Get(ctx, p.Deployment, metav1.GetOptions{})
+	replicas := int32(8)
+	deployment.Spec.Replicas = &replicas
+	deployment.ManagedFields = []metav1.ManagedFieldsEntry{replicasEntry("kubectl", time.Now())}
+	p.Client.Update(ctx, deployment, metav1.UpdateOptions{})
+	for i := 0; i < 2; i++ {
+		res := p.Scale(ctx, 75)
+		assert.Equal(t, ReasonScalingConflict, res.Reason)
+		assert.Equal(t, int32(8), replicasOf(p))
+	}
+}
+
+// replicasEntry is a managed fields entry of a manager that wrote the
+// replicas at the given time.
+func replicasEntry(manager string, at time.Time) metav1.ManagedFieldsEntry {
+	t := metav1.NewTime(at)
+	return metav1.ManagedFieldsEntry{Manager: manager, Operation: metav1.ManagedFieldsOperationUpdate, Time: &t, FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}}}`)}}
+}
+
+func TestReplicasManagedBy(t *testing.T) {
+	now := time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC)
+	entry := func(manager string, at time.Time, fields string) metav1.ManagedFieldsEntry {
+		t := metav1.NewTime(at)
+		return metav1.ManagedFieldsEntry{Manager: manager, Operation: metav1.ManagedFieldsOperationUpdate, Time: &t, FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(fields)}}
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{ManagedFields: []metav1.ManagedFieldsEntry{
+		entry(FieldManager, now.Add(-time.Minute), `{"f:spec":{"f:replicas":{}}}`),
+		entry("kube-controller-manager", now.Add(-time.Minute), `{"f:status":{"f:replicas":{}}}`),
+		entry("kubectl-client-side-apply", now.Add(-time.Hour), `{"f:spec":{"f:replicas":{}}}`),
+	}}}
+	assert.Equal(t, "", replicasManagedBy(deployment, now.Add(-DefaultConflictWindow)))
+
+	deployment.ManagedFields = append(deployment.ManagedFields, entry("kubectl", now.Add(-time.Minute), `{"f:spec":{"f:replicas":{}}}`))
+	assert.Equal(t, "kubectl changed the replicas at 2020-11-30T11:59:00Z", replicasManagedBy(deployment, now.Add(-DefaultConflictWindow)))
+}
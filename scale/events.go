@@ -0,0 +1,81 @@
+package scale
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+)
+
+// Reasons of the events recorded on the scaled deployments.
+const (
+	ReasonScaledUp         = "ScaledUp"
+	ReasonScaledDown       = "ScaledDown"
+	ReasonScaleFailed      = "ScaleFailed"
+	ReasonQueueUnreachable = "QueueUnreachable"
+	ReasonScalingConflict  = "ScalingConflict"
+	ReasonManualOverride   = "ManualOverride"
+	ReasonMetricFailure    = "MetricFailure"
+	ReasonCapacityCapped   = "CapacityCapped"
+)
+
+const eventComponent = "kube-sqs-autoscaler"
+
+// NewEventRecorder returns a recorder writing events through client and a
+// func flushing and stopping it. Similar events on the same deployment are
+// aggregated into one, so a queue that is unreachable for a while doesn't
+// flood the namespace with events.
+func NewEventRecorder(client kubernetes.Interface) (record.EventRecorder, func()) {
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		// aggregate once 5 events with the same reason but a different
+		// message, e.g. another backlog, were recorded within 10 minutes
+		MaxEvents:            5,
+		MaxIntervalInSeconds: 600,
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+	return recorder, broadcaster.Shutdown
+}
+
+// Eventf records an event on the deployment. It's a no-op without a Recorder.
+func (p *PodAutoScaler) Eventf(eventType, reason, messageFmt string, args ...interface{}) {
+	if p.Recorder == nil {
+		return
+	}
+	p.Recorder.Eventf(p.reference(), eventType, reason, messageFmt, args...)
+}
+
+// reference points to the deployment, including its uid once it was fetched
+// so the events show up in kubectl describe.
+func (p *PodAutoScaler) reference() *corev1.ObjectReference {
+	if p.ref != nil {
+		return p.ref
+	}
+	return &corev1.ObjectReference{
+		APIVersion: appsv1.SchemeGroupVersion.String(),
+		Kind:       "Deployment",
+		Name:       p.Deployment,
+		Namespace:  p.Namespace,
+	}
+}
+
+func (p *PodAutoScaler) setReference(deployment *appsv1.Deployment) {
+	// objects returned by the typed clients have no kind set
+	d := deployment.DeepCopy()
+	d.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	ref, err := reference.GetReference(scheme.Scheme, d)
+	if err != nil {
+		return
+	}
+	p.ref = ref
+}
+
+func scaledReason(current, desired int32) string {
+	if desired > current {
+		return ReasonScaledUp
+	}
+	return ReasonScaledDown
+}
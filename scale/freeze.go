@@ -0,0 +1,25 @@
+package scale
+
+import (
+	"fmt"
+	"time"
+
+	"kube-sqs-autoscaler/config"
+)
+
+// frozen returns which freeze window holds scaling from current to desired
+// replicas at now, or "" when none does.
+func (p *PodAutoScaler) frozen(now time.Time, current, desired int32) string {
+	for i := range p.FreezeWindows {
+		w := &p.FreezeWindows[i]
+		active, end := w.Active(now)
+		switch {
+		case !active:
+		case w.Mode == config.FreezeNoScaling:
+			return fmt.Sprintf("freeze window %s holds scaling until %s", w, end.UTC().Format(time.RFC3339))
+		case desired < current:
+			return fmt.Sprintf("freeze window %s holds scaling down until %s", w, end.UTC().Format(time.RFC3339))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"kube-sqs-autoscaler/config"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestScaleDownFrozen(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFakeClock(time.Date(2026, 10, 24, 1, 0, 0, 0, time.UTC))
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	p.Clock = clk
+	// every Saturday from 02:00 to 06:00 UTC
+	p.FreezeWindows = []config.FreezeWindow{{Name: "db maintenance", Mode: config.FreezeNoScaleDown, Schedule: "0 2 * * SAT", Duration: config.Duration(4 * time.Hour)}}
+
+	clk.Step(2 * time.Hour)
+	res := p.Scale(ctx, 20)
+	assert.True(t, res.ScalingSkipped)
+	assert.Equal(t, ReasonFrozen, res.Reason)
+	assert.Equal(t, DecisionFrozen, res.Decision())
+	assert.Equal(t, "keep 3 replicas, scaling is frozen", res.Action(false))
+	assert.Equal(t, "freeze window db maintenance holds scaling down until 2026-10-24T06:00:00Z", res.Steps[len(res.Steps)-1].Detail)
+	assert.Equal(t, int32(3), replicasOf(p))
+
+	// scaling up isn't frozen
+	res = p.Scale(ctx, 100)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(5), replicasOf(p))
+
+	clk.Step(3 * time.Hour)
+	res = p.Scale(ctx, 20)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(1), replicasOf(p))
+}
+
+func TestScalingFrozen(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFakeClock(time.Date(2026, 12, 24, 12, 0, 0, 0, time.UTC))
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	p.Clock = clk
+	start, end := time.Date(2026, 12, 20, 0, 0, 0, 0, time.UTC), time.Date(2027, 1, 4, 0, 0, 0, 0, time.UTC)
+	p.FreezeWindows = []config.FreezeWindow{{Mode: config.FreezeNoScaling, Start: &start, End: &end}}
+
+	res := p.Scale(ctx, 100)
+	assert.Equal(t, ReasonFrozen, res.Reason)
+	assert.Equal(t, "freeze window 2026-12-20T00:00:00Z to 2027-01-04T00:00:00Z holds scaling until 2027-01-04T00:00:00Z", res.Steps[len(res.Steps)-1].Detail)
+	assert.Equal(t, int32(3), replicasOf(p))
+
+	clk.SetTime(end)
+	p.Scale(ctx, 100)
+	assert.Equal(t, int32(5), replicasOf(p))
+}
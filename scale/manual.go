@@ -0,0 +1,35 @@
+package scale
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// manualOverride reports whether scaling holds because the replicas were
+// changed by someone else, e.g. with kubectl scale during an incident. The
+// hold starts over with every change that wasn't made by the autoscaler. The
+// changes it noticed aren't conflicts, see conflict.
+func (p *PodAutoScaler) manualOverride(current int32) bool {
+	if p.ManualOverrideHold <= 0 {
+		return false
+	}
+
+	now := p.now()
+	if p.knownReplicas != nil && *p.knownReplicas != current {
+		p.overriddenAt = now
+		p.holdUntil = now.Add(p.ManualOverrideHold)
+		p.Logger().WithField("decision", DecisionManualOverride).Warnf("[autoscaler] Replicas were changed from %d to %d outside the autoscaler, holding until %s", *p.knownReplicas, current, p.holdUntil.UTC().Format(time.RFC3339))
+		p.Eventf(corev1.EventTypeWarning, ReasonManualOverride, "Replicas were changed from %d to %d outside the autoscaler, not scaling until %s", *p.knownReplicas, current, p.holdUntil.UTC().Format(time.RFC3339))
+	}
+	p.knownReplicas = &current
+	return now.Before(p.holdUntil)
+}
+
+// now is the time of the Clock, the wall clock without one.
+func (p *PodAutoScaler) now() time.Time {
+	if p.Clock == nil {
+		return time.Now()
+	}
+	return p.Clock.Now()
+}
@@ -0,0 +1,121 @@
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestScaleHoldsAfterManualChange(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFakeClock(time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC))
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	p.Clock = clk
+	p.ManualOverrideHold = 10 * time.Minute
+	recorder := record.NewFakeRecorder(10)
+	p.Recorder = recorder
+
+	res := p.Scale(ctx, 80)
+	assert.Equal(t, int32(4), res.DesiredReplicas)
+	<-recorder.Events
+
+	// kubectl scale deployment deploy --replicas=8
+	setReplicas(p, 8)
+	res = p.Scale(ctx, 80)
+	assert.True(t, res.ScalingSkipped)
+	assert.Equal(t, ReasonManualOverride, res.Reason)
+	assert.Equal(t, DecisionManualOverride, res.Decision())
+	assert.Equal(t, "Warning ManualOverride Replicas were changed from 4 to 8 outside the autoscaler, not scaling until 2020-11-30T12:10:00Z", <-recorder.Events)
+	assert.Equal(t, int32(8), replicasOf(p))
+
+	// the hold starts over with another change
+	clk.Step(5 * time.Minute)
+	setReplicas(p, 6)
+	assert.Equal(t, ReasonManualOverride, p.Scale(ctx, 80).Reason)
+	clk.Step(9 * time.Minute)
+	assert.Equal(t, ReasonManualOverride, p.Scale(ctx, 80).Reason)
+
+	clk.Step(time.Minute)
+	res = p.Scale(ctx, 80)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(4), replicasOf(p))
+}
+
+func TestScaleAfterManualOverrideHoldIsNoConflict(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFakeClock(time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC))
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	p.Clock = clk
+	p.ManualOverrideHold = 5 * time.Minute
+	p.Scale(ctx, 80)
+
+	// kubectl scale writes the replicas as the kubectl manager
+	deployment, _ := p.Client.Get(ctx, p.Deployment, metav1.GetOptions{})
+	replicas, at := int32(8), metav1.NewTime(clk.Now())
+	deployment.Spec.Replicas = &replicas
+	deployment.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationUpdate, Time: &at, FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}}}`)}}}
+	p.Client.Update(ctx, deployment, metav1.UpdateOptions{})
+	assert.Equal(t, ReasonManualOverride, p.Scale(ctx, 80).Reason)
+
+	// the hold is shorter than the conflict window but covers the change
+	clk.Step(5 * time.Minute)
+	res := p.Scale(ctx, 80)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(4), replicasOf(p))
+}
+
+func TestScaleToIgnoresManualChanges(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFakeClock(time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC))
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	p.Clock = clk
+	p.ManualOverrideHold = 30 * time.Minute
+	p.Scale(ctx, 80)
+
+	// during an incident kubectl scale is followed by a pin of the admin API
+	deployment, _ := p.Client.Get(ctx, p.Deployment, metav1.GetOptions{})
+	replicas, at := int32(8), metav1.NewTime(clk.Now())
+	deployment.Spec.Replicas = &replicas
+	deployment.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl", Operation: metav1.ManagedFieldsOperationUpdate, Time: &at, FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:replicas":{}}}`)}}}
+	p.Client.Update(ctx, deployment, metav1.UpdateOptions{})
+	assert.Equal(t, ReasonManualOverride, p.Scale(ctx, 80).Reason)
+
+	clk.Step(time.Minute)
+	res := p.ScaleTo(ctx, 80, 10)
+	assert.Equal(t, "", res.Reason)
+	assert.False(t, res.ScalingSkipped)
+	assert.Equal(t, int32(10), replicasOf(p))
+
+	// the pin ended the hold
+	res = p.Scale(ctx, 80)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(4), replicasOf(p))
+}
+
+func TestScaleWithoutManualOverrideHold(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+
+	p.Scale(ctx, 80)
+	setReplicas(p, 8)
+	res := p.Scale(ctx, 80)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(4), replicasOf(p))
+}
+
+func setReplicas(p *PodAutoScaler, replicas int32) {
+	deployment, _ := p.Client.Get(context.Background(), p.Deployment, metav1.GetOptions{})
+	deployment.Spec.Replicas = &replicas
+	p.Client.Update(context.Background(), deployment, metav1.UpdateOptions{})
+}
+
+func replicasOf(p *PodAutoScaler) int32 {
+	deployment, _ := p.Client.Get(context.Background(), p.Deployment, metav1.GetOptions{})
+	return *deployment.Spec.Replicas
+}
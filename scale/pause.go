@@ -0,0 +1,33 @@
+package scale
+
+import (
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Annotations owners put on their deployment to stop it from being scaled.
+// AnnotationPausedUntil optionally ends the pause at an RFC3339 time.
+const (
+	AnnotationPaused      = AnnotationPrefix + "paused"
+	AnnotationPausedUntil = AnnotationPrefix + "paused-until"
+)
+
+// pausedByAnnotation reports whether the deployment is paused at now and
+// until when. A pause with an unreadable expiry holds until the annotation is
+// fixed or removed.
+func (p *PodAutoScaler) pausedByAnnotation(deployment *appsv1.Deployment, now time.Time) (bool, *time.Time) {
+	if deployment.Annotations[AnnotationPaused] != "true" {
+		return false, nil
+	}
+	value, ok := deployment.Annotations[AnnotationPausedUntil]
+	if !ok {
+		return true, nil
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		p.Logger().Warnf("[autoscaler] Invalid %s annotation %q, staying paused: %v", AnnotationPausedUntil, value, err)
+		return true, nil
+	}
+	return now.Before(until), &until
+}
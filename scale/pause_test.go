@@ -0,0 +1,56 @@
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScalePausedByAnnotation(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	annotate(p, map[string]string{AnnotationPaused: "true"})
+
+	res := p.Scale(ctx, 75)
+	assert.Nil(t, res.Err)
+	assert.True(t, res.ScalingSkipped)
+	assert.Equal(t, ReasonPaused, res.Reason)
+	assert.Equal(t, DecisionPaused, res.Decision())
+	assert.Equal(t, int32(4), res.DesiredReplicas)
+	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+
+	// other values don't pause
+	annotate(p, map[string]string{AnnotationPaused: "false"})
+	res = p.Scale(ctx, 75)
+	assert.Equal(t, "", res.Reason)
+	deployment, _ = p.Client.Get(ctx, "deploy", metav1.GetOptions{})
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas)
+}
+
+func TestScalePausedUntil(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+
+	annotate(p, map[string]string{AnnotationPaused: "true", AnnotationPausedUntil: time.Now().Add(time.Hour).Format(time.RFC3339)})
+	assert.Equal(t, ReasonPaused, p.Scale(ctx, 75).Reason)
+
+	annotate(p, map[string]string{AnnotationPaused: "true", AnnotationPausedUntil: "tomorrow"})
+	assert.Equal(t, ReasonPaused, p.Scale(ctx, 75).Reason, "an invalid expiry keeps the pause")
+
+	annotate(p, map[string]string{AnnotationPaused: "true", AnnotationPausedUntil: time.Now().Add(-time.Hour).Format(time.RFC3339)})
+	res := p.Scale(ctx, 75)
+	assert.Equal(t, "", res.Reason)
+	assert.False(t, res.ScalingSkipped)
+	assert.Equal(t, int32(4), res.DesiredReplicas)
+}
+
+func annotate(p *PodAutoScaler, annotations map[string]string) {
+	deployment, _ := p.Client.Get(context.Background(), p.Deployment, metav1.GetOptions{})
+	deployment.Annotations = annotations
+	p.Client.Update(context.Background(), deployment, metav1.UpdateOptions{})
+}
@@ -0,0 +1,53 @@
+package scale
+
+import (
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// PendingTimeout bounds how long scaling up waits for the replicas of the
+// last scale up to become available, e.g. while they can't be scheduled.
+const PendingTimeout = 5 * time.Minute
+
+// scaleUp is a scale up made by the autoscaler.
+type scaleUp struct {
+	from, to int32
+	at       time.Time
+}
+
+// notReady reports why the deployment isn't scaled from current to desired
+// replicas with ReadinessAware set, or "" when it can be: scaling down waits
+// for a rollout to finish and scaling up for the replicas the last scale up
+// added to become available.
+func (p *PodAutoScaler) notReady(deployment *appsv1.Deployment, current, desired int32, now time.Time) (reason, detail string) {
+	status := deployment.Status
+	rollout := rolloutInProgress(deployment)
+	switch {
+	case desired < current && rollout:
+		return ReasonRolloutInProgress, fmt.Sprintf("a rollout is in progress, %d of %d replicas are updated", status.UpdatedReplicas, current)
+	case desired > current && !rollout && p.pendingScaleUp(status.AvailableReplicas, current, now):
+		return ReasonPodsPending, fmt.Sprintf("%d of %d replicas are available", status.AvailableReplicas, current)
+	}
+	return "", ""
+}
+
+// pendingScaleUp tells if replicas the last scale up to current added aren't
+// available yet. Unavailable replicas from before, e.g. crash looping pods,
+// don't hold scaling up, and neither do replicas pending for longer than
+// PendingTimeout.
+func (p *PodAutoScaler) pendingScaleUp(available, current int32, now time.Time) bool {
+	up := p.lastScaleUp
+	return up != nil && up.to == current && now.Before(up.at.Add(PendingTimeout)) && available >= up.from && available < up.to
+}
+
+// rolloutInProgress tells if the deployment controller is still replacing
+// pods, like kubectl rollout status does.
+func rolloutInProgress(deployment *appsv1.Deployment) bool {
+	status := deployment.Status
+	if deployment.Generation > status.ObservedGeneration {
+		return true
+	}
+	return status.UpdatedReplicas < *deployment.Spec.Replicas || status.Replicas > status.UpdatedReplicas
+}
@@ -0,0 +1,103 @@
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestScaleDownWaitsForRollout(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	p.ReadinessAware = true
+
+	// 1 of 3 replicas runs the new version
+	setStatus(p, 2, appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: 1, AvailableReplicas: 3})
+	res := p.Scale(ctx, 20)
+	assert.True(t, res.ScalingSkipped)
+	assert.Equal(t, ReasonRolloutInProgress, res.Reason)
+	assert.Equal(t, DecisionRolloutInProgress, res.Decision())
+	assert.Equal(t, "keep 3 replicas until the rollout finished", res.Action(false))
+	assert.Equal(t, "a rollout is in progress, 1 of 3 replicas are updated", res.Steps[len(res.Steps)-1].Detail)
+	assert.Equal(t, int32(3), replicasOf(p))
+
+	// scaling up isn't held by a rollout
+	res = p.Scale(ctx, 100)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(5), replicasOf(p))
+
+	setStatus(p, 3, appsv1.DeploymentStatus{ObservedGeneration: 3, Replicas: 5, UpdatedReplicas: 5, AvailableReplicas: 5})
+	res = p.Scale(ctx, 20)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(1), replicasOf(p))
+}
+
+func TestScaleUpWaitsForPendingPods(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFakeClock(time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC))
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	p.Clock = clk
+	p.ReadinessAware = true
+	p.Scale(ctx, 100)
+
+	// 2 of the 5 replicas are still pending
+	setStatus(p, 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 5, UpdatedReplicas: 5, AvailableReplicas: 3})
+	res := p.Scale(ctx, 160)
+	assert.True(t, res.ScalingSkipped)
+	assert.Equal(t, ReasonPodsPending, res.Reason)
+	assert.Equal(t, DecisionPodsPending, res.Decision())
+	assert.Equal(t, "3 of 5 replicas are available", res.Steps[len(res.Steps)-1].Detail)
+	assert.Equal(t, int32(5), replicasOf(p))
+
+	// pods pending for too long don't hold scaling up any more
+	clk.Step(PendingTimeout)
+	res = p.Scale(ctx, 160)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(8), replicasOf(p))
+
+	// scaling down isn't held by pending pods
+	setStatus(p, 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 8, UpdatedReplicas: 8, AvailableReplicas: 5})
+	res = p.Scale(ctx, 20)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(1), replicasOf(p))
+}
+
+func TestScaleUpIgnoresUnavailableReplicasFromBefore(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	p.ReadinessAware = true
+
+	// 2 of 3 replicas crash loop
+	setStatus(p, 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 1})
+	res := p.Scale(ctx, 100)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(5), replicasOf(p))
+
+	// and the replicas added by the scale up aren't waited for either
+	setStatus(p, 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 5, UpdatedReplicas: 5, AvailableReplicas: 1})
+	res = p.Scale(ctx, 160)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(8), replicasOf(p))
+}
+
+func TestScaleIgnoresReadinessByDefault(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	setStatus(p, 1, appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 1})
+
+	res := p.Scale(context.Background(), 100)
+	assert.Equal(t, "", res.Reason)
+	assert.Equal(t, int32(5), replicasOf(p))
+}
+
+func setStatus(p *PodAutoScaler, generation int64, status appsv1.DeploymentStatus) {
+	deployment, _ := p.Client.Get(context.Background(), p.Deployment, metav1.GetOptions{})
+	deployment.Generation = generation
+	deployment.Status = status
+	p.Client.Update(context.Background(), deployment, metav1.UpdateOptions{})
+}
@@ -0,0 +1,83 @@
+package scale
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// getDeployment reads the deployment, retrying with the Retry policy.
+func (p *PodAutoScaler) getDeployment(ctx context.Context) (*appsv1.Deployment, error) {
+	var deployment *appsv1.Deployment
+	err := p.Retry.Do(ctx, isRetryable, p.Metrics.Attempts("GetDeployment"), func() (err error) {
+		start := time.Now()
+		deployment, err = p.Client.Get(ctx, p.Deployment, metav1.GetOptions{})
+		p.Metrics.ObserveKubernetes("GetDeployment", start)
+		return err
+	})
+	return deployment, err
+}
+
+// updateReplicas writes deployment with the given replicas. On a conflict the
+// latest version is read and updated instead, unless its replicas aren't the
+// observed ones anymore, as the scaling decision was made on those.
+func (p *PodAutoScaler) updateReplicas(ctx context.Context, deployment *appsv1.Deployment, observed, replicas int32) error {
+	deployment.Spec.Replicas = &replicas
+	return p.Retry.Do(ctx, isRetryable, p.Metrics.Attempts("UpdateDeployment"), func() error {
+		start := time.Now()
+		_, err := p.Client.Update(ctx, deployment, metav1.UpdateOptions{FieldManager: FieldManager})
+		p.Metrics.ObserveKubernetes("UpdateDeployment", start)
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+
+		latest, getErr := p.Client.Get(ctx, p.Deployment, metav1.GetOptions{})
+		if getErr != nil {
+			return err
+		}
+		if latest.Spec.Replicas == nil || *latest.Spec.Replicas != observed {
+			return errors.Errorf("replicas changed while scaling from %d to %d", observed, replicas)
+		}
+		latest.Spec.Replicas = &replicas
+		deployment = latest
+		return err
+	})
+}
+
+// patchDeployment merge patches the deployment, retrying with the Retry
+// policy.
+func (p *PodAutoScaler) patchDeployment(ctx context.Context, patch []byte) error {
+	return p.Retry.Do(ctx, isRetryable, p.Metrics.Attempts("PatchDeployment"), func() error {
+		start := time.Now()
+		_, err := p.Client.Patch(ctx, p.Deployment, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: FieldManager})
+		p.Metrics.ObserveKubernetes("PatchDeployment", start)
+		return err
+	})
+}
+
+// isRetryable tells conflicts, throttling, server side and connection errors,
+// which may pass on their own, from fatal ones like a missing deployment or
+// permission.
+func isRetryable(err error) bool {
+	switch {
+	case apierrors.IsConflict(err),
+		apierrors.IsTooManyRequests(err),
+		apierrors.IsServerTimeout(err),
+		apierrors.IsTimeout(err),
+		apierrors.IsInternalError(err),
+		apierrors.IsServiceUnavailable(err),
+		apierrors.IsUnexpectedServerError(err):
+		return true
+	case utilnet.IsConnectionRefused(err), utilnet.IsConnectionReset(err), utilnet.IsProbableEOF(err):
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
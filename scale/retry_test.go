@@ -0,0 +1,92 @@
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+
+	"kube-sqs-autoscaler/retry"
+)
+
+var deploymentsResource = schema.GroupResource{Group: "apps", Resource: "deployments"}
+
+func TestScaleRetriesConflicts(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.Retry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	client := fakeClient(p)
+	updates := 0
+	client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates == 1 {
+			return true, nil, apierrors.NewConflict(deploymentsResource, "deploy", nil)
+		}
+		return false, nil, nil
+	})
+
+	res := p.Scale(context.Background(), 75)
+	assert.Nil(t, res.Err)
+	assert.Equal(t, 2, updates)
+	assert.Equal(t, int32(4), replicasOf(p))
+}
+
+func TestScaleDoesNotRetryWhenReplicasChanged(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.Retry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	client := fakeClient(p)
+	updates := 0
+	client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		return true, nil, apierrors.NewConflict(deploymentsResource, "deploy", nil)
+	})
+	client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if updates == 0 {
+			return false, nil, nil
+		}
+		// someone scaled the deployment in between
+		replicas := int32(8)
+		return true, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "deploy", Namespace: "namespace"}, Spec: appsv1.DeploymentSpec{Replicas: &replicas}}, nil
+	})
+
+	res := p.Scale(context.Background(), 75)
+	assert.EqualError(t, res.Err, "Failed to scale: replicas changed while scaling from 3 to 4")
+	assert.Equal(t, 1, updates)
+}
+
+func TestScaleDoesNotRetryFatalErrors(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.Retry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	client := fakeClient(p)
+	gets := 0
+	client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return true, nil, apierrors.NewForbidden(deploymentsResource, "deploy", nil)
+	})
+
+	res := p.Scale(context.Background(), 75)
+	assert.NotNil(t, res.Err)
+	assert.Equal(t, 1, gets)
+}
+
+func TestScaleGivesUpAfterMaxAttempts(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.Retry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	client := fakeClient(p)
+	gets := 0
+	client.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return true, nil, apierrors.NewTooManyRequests("slow down", 1)
+	})
+
+	res := p.Scale(context.Background(), 75)
+	assert.NotNil(t, res.Err)
+	assert.Equal(t, 3, gets)
+}
@@ -2,7 +2,15 @@ package scale
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"time"
+
+	"kube-sqs-autoscaler/budget"
+	"kube-sqs-autoscaler/config"
+	"kube-sqs-autoscaler/decision"
+	"kube-sqs-autoscaler/metrics"
+	"kube-sqs-autoscaler/retry"
 
 	"github.com/pkg/errors"
 
@@ -10,19 +18,113 @@ import (
 
 	"math"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes"
 	typedappv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	typedautoscalingv1 "k8s.io/client-go/kubernetes/typed/autoscaling/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
 	kubeConfigPath string
 )
 
+// Decisions logged in the decision field next to the scaling directions of
+// the metrics package.
+const (
+	DecisionCoolDown            = "cooldown"
+	DecisionZeroScalingCoolDown = "zero_scaling_cooldown"
+	DecisionError               = "error"
+	DecisionPaused              = "paused"
+	DecisionConflict            = "conflict"
+	DecisionManualOverride      = "manual_override"
+	DecisionRolloutInProgress   = "rollout_in_progress"
+	DecisionPodsPending         = "pods_pending"
+	DecisionFrozen              = "frozen"
+)
+
+// UnknownBacklog is passed as the backlog when it couldn't be read, see
+// FailSafe.
+const UnknownBacklog = -1
+
+// ScalingResult reasons of a deployment paused with the paused annotation, of
+// a deployment that isn't ready to be scaled, see ReadinessAware, and of one
+// held by a freeze window.
+const (
+	ReasonPaused            = "Paused"
+	ReasonRolloutInProgress = "RolloutInProgress"
+	ReasonPodsPending       = "PodsPending"
+	ReasonFrozen            = "Frozen"
+)
+
 type ScalingResult struct {
-	Err            error
-	ScalingSkipped bool
+	Err             error
+	ScalingSkipped  bool
+	CurrentReplicas int32
+	DesiredReplicas int32
+	// Steps explain how DesiredReplicas was reached.
+	Steps []decision.Step
+	// Reason tells why scaling was skipped when it wasn't up to the
+	// backlog, ReasonPaused, ReasonScalingConflict, ReasonManualOverride,
+	// ReasonRolloutInProgress, ReasonPodsPending or ReasonFrozen.
+	Reason string
+}
+
+// Decision is the decision logged for the result.
+func (r *ScalingResult) Decision() string {
+	if r.Err != nil {
+		return DecisionError
+	}
+	switch r.Reason {
+	case ReasonPaused:
+		return DecisionPaused
+	case ReasonScalingConflict:
+		return DecisionConflict
+	case ReasonManualOverride:
+		return DecisionManualOverride
+	case ReasonRolloutInProgress:
+		return DecisionRolloutInProgress
+	case ReasonPodsPending:
+		return DecisionPodsPending
+	case ReasonFrozen:
+		return DecisionFrozen
+	}
+	return scaleDirection(r.CurrentReplicas, r.DesiredReplicas)
+}
+
+// Action describes the result in a decision record.
+func (r *ScalingResult) Action(dryRun bool) string {
+	direction := "up"
+	if r.DesiredReplicas < r.CurrentReplicas {
+		direction = "down"
+	}
+	switch {
+	case r.Err != nil && r.CurrentReplicas == r.DesiredReplicas:
+		return "none, the deployment couldn't be read"
+	case r.Err != nil:
+		return fmt.Sprintf("failed to scale %s from %d to %d replicas", direction, r.CurrentReplicas, r.DesiredReplicas)
+	case r.Reason == ReasonPaused:
+		return fmt.Sprintf("keep %d replicas, paused by the %s annotation", r.CurrentReplicas, AnnotationPaused)
+	case r.Reason == ReasonScalingConflict:
+		return fmt.Sprintf("keep %d replicas, another controller scales the deployment", r.CurrentReplicas)
+	case r.Reason == ReasonManualOverride:
+		return fmt.Sprintf("keep %d replicas, they were changed outside the autoscaler", r.CurrentReplicas)
+	case r.Reason == ReasonRolloutInProgress:
+		return fmt.Sprintf("keep %d replicas until the rollout finished", r.CurrentReplicas)
+	case r.Reason == ReasonPodsPending:
+		return fmt.Sprintf("keep %d replicas until they are available", r.CurrentReplicas)
+	case r.Reason == ReasonFrozen:
+		return fmt.Sprintf("keep %d replicas, scaling is frozen", r.CurrentReplicas)
+	case r.CurrentReplicas == r.DesiredReplicas:
+		return fmt.Sprintf("keep %d replicas", r.CurrentReplicas)
+	case dryRun:
+		return fmt.Sprintf("would scale %s from %d to %d replicas (dry-run)", direction, r.CurrentReplicas, r.DesiredReplicas)
+	default:
+		return fmt.Sprintf("scaled %s from %d to %d replicas", direction, r.CurrentReplicas, r.DesiredReplicas)
+	}
 }
 
 type PodAutoScaler struct {
@@ -34,9 +136,57 @@ type PodAutoScaler struct {
 	ZeroScaling   bool
 	MessagePerPod int
 	DryRun        bool
+	Metrics       *metrics.Scaler
+	Recorder      record.EventRecorder
+	// StatusAnnotations makes WriteStatus annotate the deployment with the
+	// last scaling decision, InstanceId names this autoscaler in them.
+	StatusAnnotations bool
+	InstanceId        string
+	// PersistCoolDowns makes WriteStatus keep the cooldown timers in
+	// annotations so RestoreCoolDowns can pick them up after a restart.
+	PersistCoolDowns bool
+	// Log carries the fields identifying this scaler, see Logger.
+	Log *log.Entry
+	// HPAs are checked for a HorizontalPodAutoscaler scaling the deployment
+	// too, and ConflictWindow is how recent a write of the replicas by another
+	// manager has to be to count as a conflict. Scaling is refused on a
+	// conflict unless IgnoreConflicts is set.
+	HPAs            typedautoscalingv1.HorizontalPodAutoscalerInterface
+	ConflictWindow  time.Duration
+	IgnoreConflicts bool
+	// ManualOverrideHold is how long scaling holds after the replicas were
+	// changed outside the autoscaler. 0 turns the detection off.
+	ManualOverrideHold time.Duration
+	// Pods are listed with CapacityAware set to cap the replicas at the pods
+	// that can be scheduled plus CapacityHeadroom while some can't.
+	Pods             typedcorev1.PodInterface
+	CapacityAware    bool
+	CapacityHeadroom int
+	// Budget shares the total and group replica budgets with the other
+	// deployments. Without one only Max limits the replicas.
+	Budget *budget.Member
+	// FreezeWindows hold scaling down, or all scaling, while they are open.
+	FreezeWindows []config.FreezeWindow
+	// ReadinessAware holds scaling down while a rollout is in progress and
+	// scaling up while replicas requested before aren't available yet.
+	ReadinessAware bool
+	// Retry retries the Kubernetes calls failing with retryable errors.
+	Retry retry.Policy
+	// Clock is used for the pause, conflict and hold times, the wall clock
+	// without one.
+	Clock clock.PassiveClock
+
+	ref           *corev1.ObjectReference
+	writtenStatus map[string]*string
+	knownReplicas *int32
+	holdUntil     time.Time
+	overriddenAt  time.Time
+	lastScaleUp   *scaleUp
 }
 
-func NewPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace string, max, min, messagePerPod int, zeroScaling bool, dryRun bool) *PodAutoScaler {
+// NewKubeClient builds a client from KUBE_CONFIG_PATH or the in cluster
+// config. It's shared by all the scalers.
+func NewKubeClient() kubernetes.Interface {
 	kubeConfigPath = os.Getenv("KUBE_CONFIG_PATH")
 	config, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
 	if err != nil {
@@ -47,7 +197,10 @@ func NewPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace strin
 	if err != nil {
 		panic("Failed to configure client")
 	}
+	return k8sClient
+}
 
+func NewPodAutoScaler(k8sClient kubernetes.Interface, kubernetesDeploymentName string, kubernetesNamespace string, max, min, messagePerPod int, zeroScaling bool, dryRun bool) *PodAutoScaler {
 	return &PodAutoScaler{
 		Client:        k8sClient.AppsV1().Deployments(kubernetesNamespace),
 		Min:           min,
@@ -57,66 +210,312 @@ func NewPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace strin
 		ZeroScaling:   zeroScaling,
 		MessagePerPod: messagePerPod,
 		DryRun:        dryRun,
+
+		HPAs:           k8sClient.AutoscalingV1().HorizontalPodAutoscalers(kubernetesNamespace),
+		Pods:           k8sClient.CoreV1().Pods(kubernetesNamespace),
+		ConflictWindow: DefaultConflictWindow,
 	}
 }
 
+// Logger returns the entry every line about this scaler is logged with. It
+// defaults to the deployment and namespace fields.
+func (p *PodAutoScaler) Logger() *log.Entry {
+	if p.Log == nil {
+		p.Log = log.WithFields(log.Fields{"deployment": p.Deployment, "namespace": p.Namespace})
+	}
+	return p.Log
+}
+
 func (p *PodAutoScaler) Scale(ctx context.Context, numMessages int) *ScalingResult {
-	deployment, err := p.Client.Get(ctx, p.Deployment, metav1.GetOptions{})
+	return p.scale(ctx, numMessages, false, p.getDesiredReplicaCount)
+}
+
+// ScaleTo scales to replicas regardless of the backlog, min and max, e.g.
+// when an operator pinned the replicas. As the operator took over, replicas
+// changed by hand and other managers of the replicas don't hold it.
+func (p *PodAutoScaler) ScaleTo(ctx context.Context, numMessages int, replicas int32) *ScalingResult {
+	return p.scale(ctx, numMessages, true, func(int) (int32, []decision.Step) {
+		return replicas, []decision.Step{decision.NewStep("pinned", "pinned to %d replicas", replicas)}
+	})
+}
+
+// FailSafe scales to replicas while the backlog can't be read, detail tells
+// why. See the onMetricFailure config.
+func (p *PodAutoScaler) FailSafe(ctx context.Context, replicas int32, detail string) *ScalingResult {
+	return p.scale(ctx, UnknownBacklog, false, func(int) (int32, []decision.Step) {
+		return replicas, []decision.Step{decision.NewStep("onMetricFailure", "%s, scaling to %d replicas", detail, replicas)}
+	})
+}
+
+// ReportReplicas reports the replicas of the deployment to its Budget, so the
+// deployments sharing it know them before this one evaluates the first time.
+func (p *PodAutoScaler) ReportReplicas(ctx context.Context) error {
+	if p.Budget == nil {
+		return nil
+	}
+	deployment, err := p.getDeployment(ctx)
+	if err != nil {
+		return err
+	}
+	p.Budget.Report(*deployment.Spec.Replicas)
+	return nil
+}
+
+func (p *PodAutoScaler) scale(ctx context.Context, numMessages int, pinned bool, desired func(int) (int32, []decision.Step)) *ScalingResult {
+	deployment, err := p.getDeployment(ctx)
 	if err != nil {
+		p.Logger().WithFields(log.Fields{"backlog": numMessages, "decision": DecisionError}).Errorf("[autoscaler] Failed to get deployment: %v", err)
+		p.Metrics.ScaleEvent(metrics.DirectionNone, metrics.ResultFailure)
+		p.Eventf(corev1.EventTypeWarning, ReasonScaleFailed, "Failed to get deployment: %v", err)
 		return &ScalingResult{
 			Err:            errors.Wrap(err, "Failed to get deployment from kube server, no scale down occured"),
 			ScalingSkipped: true,
 		}
 	}
 
+	p.setReference(deployment)
+
 	currentReplicas := deployment.Spec.Replicas
-	desiredReplicas := p.getDesiredReplicaCount(numMessages)
+	p.Budget.Report(*currentReplicas)
+	if p.knownReplicas == nil {
+		// changes made before the first poll can't be told apart
+		p.knownReplicas = currentReplicas
+	}
+	desiredReplicas, steps := desired(numMessages)
+	if p.Budget != nil {
+		granted, detail := p.Budget.Allocate(*currentReplicas, desiredReplicas)
+		// the budget doesn't take the deployment below the min it would have
+		// had anyway
+		if min := int32(p.Min); !p.ZeroScaling && granted < min && desiredReplicas >= min {
+			granted = min
+			detail = fmt.Sprintf("%s, raised to the min of %d", detail, p.Min)
+		}
+		p.Metrics.SetBudgetCapped(desiredReplicas - granted)
+		if detail != "" {
+			p.Logger().Infof("[autoscaler] %s", detail)
+			steps = append(steps, decision.NewStep("budget", "%s", detail))
+			desiredReplicas = granted
+		}
+	}
+	steps = append(steps, decision.NewStep("current", "%d replicas", *currentReplicas))
+	p.Metrics.SetReplicas(*currentReplicas, desiredReplicas)
+	direction := scaleDirection(*currentReplicas, desiredReplicas)
+	fields := log.Fields{
+		"current":  *currentReplicas,
+		"desired":  desiredReplicas,
+		"decision": direction,
+	}
+	if numMessages != UnknownBacklog {
+		fields["backlog"] = numMessages
+	}
+	logger := p.Logger().WithFields(fields)
+
+	if paused, until := p.pausedByAnnotation(deployment, p.now()); paused {
+		// changes made while paused aren't manual overrides
+		p.knownReplicas = currentReplicas
+		p.Metrics.ScaleEvent(direction, metrics.ResultPaused)
+		if until != nil {
+			logger = logger.WithField("pausedUntil", until.UTC().Format(time.RFC3339))
+			steps = append(steps, decision.NewStep("paused", "by the %s annotation until %s", AnnotationPaused, until.UTC().Format(time.RFC3339)))
+		} else {
+			steps = append(steps, decision.NewStep("paused", "by the %s annotation", AnnotationPaused))
+		}
+		logger.WithField("decision", DecisionPaused).Info("[autoscaler] Deployment is paused by annotation")
+		return &ScalingResult{
+			ScalingSkipped:  true,
+			CurrentReplicas: *currentReplicas,
+			DesiredReplicas: desiredReplicas,
+			Steps:           steps,
+			Reason:          ReasonPaused,
+		}
+	}
+
+	if pinned {
+		// the pin replaces a running manual override hold
+		p.knownReplicas, p.holdUntil = currentReplicas, time.Time{}
+	} else if p.manualOverride(*currentReplicas) {
+		p.Metrics.ScaleEvent(direction, metrics.ResultManualOverride)
+		logger.WithField("decision", DecisionManualOverride).Infof("[autoscaler] Replicas were changed outside the autoscaler, holding until %s", p.holdUntil.UTC().Format(time.RFC3339))
+		return &ScalingResult{
+			ScalingSkipped:  true,
+			CurrentReplicas: *currentReplicas,
+			DesiredReplicas: desiredReplicas,
+			Steps:           append(steps, decision.NewStep("manualOverride", "replicas were changed outside the autoscaler, holding until %s", p.holdUntil.UTC().Format(time.RFC3339))),
+			Reason:          ReasonManualOverride,
+		}
+	}
 
+	same := func() *ScalingResult {
+		p.Metrics.ScaleEvent(metrics.DirectionNone, metrics.ResultSkipped)
+		logger.Info("[autoscaler] Same as desired replicas")
+		return &ScalingResult{
+			Err:             errors.Wrap(err, "Failed to get deployment from kube server, no scale down occured"),
+			ScalingSkipped:  true,
+			CurrentReplicas: *currentReplicas,
+			DesiredReplicas: desiredReplicas,
+			Steps:           steps,
+		}
+	}
 	if *currentReplicas == desiredReplicas {
-		log.Infof("[autoscaler] Same as desired replicas. Current replicas: %d Desired replicas: %d", *deployment.Spec.Replicas, desiredReplicas)
+		return same()
+	}
+
+	if frozen := p.frozen(p.now(), *currentReplicas, desiredReplicas); frozen != "" {
+		p.Metrics.ScaleEvent(direction, metrics.ResultFrozen)
+		logger.WithField("decision", DecisionFrozen).Infof("[autoscaler] Not scaling, %s", frozen)
 		return &ScalingResult{
-			Err:            errors.Wrap(err, "Failed to get deployment from kube server, no scale down occured"),
-			ScalingSkipped: true,
+			ScalingSkipped:  true,
+			CurrentReplicas: *currentReplicas,
+			DesiredReplicas: desiredReplicas,
+			Steps:           append(steps, decision.NewStep("freeze", "%s", frozen)),
+			Reason:          ReasonFrozen,
+		}
+	}
+
+	if p.ReadinessAware {
+		if reason, detail := p.notReady(deployment, *currentReplicas, desiredReplicas, p.now()); reason != "" {
+			result := &ScalingResult{
+				ScalingSkipped:  true,
+				CurrentReplicas: *currentReplicas,
+				DesiredReplicas: desiredReplicas,
+				Steps:           append(steps, decision.NewStep("readiness", "%s", detail)),
+				Reason:          reason,
+			}
+			if reason == ReasonPodsPending {
+				p.Metrics.ScaleEvent(direction, metrics.ResultPodsPending)
+			} else {
+				p.Metrics.ScaleEvent(direction, metrics.ResultRolloutInProgress)
+			}
+			logger.WithField("decision", result.Decision()).Infof("[autoscaler] Not scaling, %s", detail)
+			return result
 		}
 	}
 
-	deployment.Spec.Replicas = &desiredReplicas
+	// the pods are only listed for deployments that are about to be scaled up
+	if p.CapacityAware {
+		var detail string
+		if desiredReplicas, detail = p.capAtCapacity(ctx, deployment, *currentReplicas, desiredReplicas); detail != "" {
+			steps = append(steps, decision.NewStep("capacity", "%s", detail))
+			p.Metrics.SetReplicas(*currentReplicas, desiredReplicas)
+			direction = scaleDirection(*currentReplicas, desiredReplicas)
+			logger = logger.WithFields(log.Fields{"desired": desiredReplicas, "decision": direction})
+			if *currentReplicas == desiredReplicas {
+				return same()
+			}
+		}
+	}
+
+	if conflict := p.conflict(ctx, deployment, p.now()); conflict != "" {
+		p.Metrics.SetConflict(true)
+		if !p.IgnoreConflicts && !pinned {
+			p.Metrics.ScaleEvent(direction, metrics.ResultConflict)
+			logger.WithField("decision", DecisionConflict).Warnf("[autoscaler] Not scaling, %s", conflict)
+			p.Eventf(corev1.EventTypeWarning, ReasonScalingConflict, "Not scaling from %d to %d replicas, %s", *currentReplicas, desiredReplicas, conflict)
+			return &ScalingResult{
+				ScalingSkipped:  true,
+				CurrentReplicas: *currentReplicas,
+				DesiredReplicas: desiredReplicas,
+				Steps:           append(steps, decision.NewStep("conflict", "%s", conflict)),
+				Reason:          ReasonScalingConflict,
+			}
+		}
+		steps = append(steps, decision.NewStep("conflict", "%s, ignored", conflict))
+	} else {
+		p.Metrics.SetConflict(false)
+	}
+
+	oldReplicas := *currentReplicas
 
 	if p.DryRun {
-		log.Infof("[autoscaler] [DryRun] would scale deployment %s to %d replicas", p.Deployment, desiredReplicas)
+		p.Metrics.ScaleEvent(direction, metrics.ResultDryRun)
+		logger.Info("[autoscaler] [DryRun] would scale deployment")
+		steps = append(steps, decision.NewStep("dryRun", "the deployment isn't updated"))
 		return &ScalingResult{
-			Err:            errors.Wrap(err, "Failed to get deployment from kube server, no scale down occured"),
-			ScalingSkipped: false,
+			Err:             errors.Wrap(err, "Failed to get deployment from kube server, no scale down occured"),
+			ScalingSkipped:  false,
+			CurrentReplicas: oldReplicas,
+			DesiredReplicas: desiredReplicas,
+			Steps:           steps,
 		}
 	}
 
-	_, err = p.Client.Update(ctx, deployment, metav1.UpdateOptions{})
+	err = p.updateReplicas(ctx, deployment, oldReplicas, desiredReplicas)
 	if err != nil {
+		logger.WithField("decision", DecisionError).Errorf("[autoscaler] Failed to scale: %v", err)
+		p.Metrics.ScaleEvent(direction, metrics.ResultFailure)
+		p.Eventf(corev1.EventTypeWarning, ReasonScaleFailed, "Failed to scale from %d to %d replicas %s: %v", oldReplicas, desiredReplicas, backlogDetail(numMessages), err)
 		return &ScalingResult{
-			Err:            errors.Wrap(err, "Failed to scale"),
-			ScalingSkipped: true,
+			Err:             errors.Wrap(err, "Failed to scale"),
+			ScalingSkipped:  true,
+			CurrentReplicas: oldReplicas,
+			DesiredReplicas: desiredReplicas,
+			Steps:           steps,
 		}
 	}
 
-	log.Infof("[autoscaler] Scaling successful. Replicas: %d", *deployment.Spec.Replicas)
+	p.knownReplicas = &desiredReplicas
+	p.lastScaleUp = nil
+	if desiredReplicas > oldReplicas {
+		p.lastScaleUp = &scaleUp{from: oldReplicas, to: desiredReplicas, at: p.now()}
+	}
+	p.Budget.Scaled(desiredReplicas)
+	p.Metrics.ScaleEvent(direction, metrics.ResultSuccess)
+	p.Eventf(corev1.EventTypeNormal, scaledReason(oldReplicas, desiredReplicas), "Scaled from %d to %d replicas %s", oldReplicas, desiredReplicas, backlogDetail(numMessages))
+	logger.Info("[autoscaler] Scaling successful")
 	return &ScalingResult{
-		Err:            nil,
-		ScalingSkipped: false,
+		Err:             nil,
+		ScalingSkipped:  false,
+		CurrentReplicas: oldReplicas,
+		DesiredReplicas: desiredReplicas,
+		Steps:           steps,
 	}
 }
 
-func (p *PodAutoScaler) getDesiredReplicaCount(numMessages int) int32 {
+// getDesiredReplicaCount returns the replicas for the backlog and the steps
+// explaining them.
+func (p *PodAutoScaler) getDesiredReplicaCount(numMessages int) (int32, []decision.Step) {
 	desiredReplicas := int(math.Ceil(float64(numMessages) / float64(p.MessagePerPod)))
+	steps := []decision.Step{
+		decision.NewStep("messagePerPod", "ceil(%d messages / %d per pod) = %d replicas", numMessages, p.MessagePerPod, desiredReplicas),
+	}
 
-	if p.ZeroScaling == false && desiredReplicas < int(p.Min) {
-		log.Infof("[autoscaler] desired replicas are less than min pods resetting to min. Min pod: %d Desired replicas: %d", p.Min, desiredReplicas)
+	switch {
+	case p.ZeroScaling:
+		steps = append(steps, decision.NewStep("zeroScaling", "enabled, the min of %d isn't applied", p.Min))
+	case desiredReplicas < int(p.Min):
+		p.Logger().WithFields(log.Fields{"backlog": numMessages, "desired": desiredReplicas}).Infof("[autoscaler] desired replicas are less than min pods resetting to min. Min pod: %d", p.Min)
+		steps = append(steps, decision.NewStep("min", "%d raised to the min of %d", desiredReplicas, p.Min))
 		desiredReplicas = int(p.Min)
+	default:
+		steps = append(steps, decision.NewStep("min", "%d is within the min of %d", desiredReplicas, p.Min))
 	}
 
 	if desiredReplicas > int(p.Max) {
-		log.Infof("[autoscaler] desired replicas are more than max pods resetting to max. Max pod: %d Desired replicas: %d", p.Max, desiredReplicas)
+		p.Logger().WithFields(log.Fields{"backlog": numMessages, "desired": desiredReplicas}).Infof("[autoscaler] desired replicas are more than max pods resetting to max. Max pod: %d", p.Max)
+		steps = append(steps, decision.NewStep("max", "%d capped to the max of %d", desiredReplicas, p.Max))
 		desiredReplicas = int(p.Max)
+	} else {
+		steps = append(steps, decision.NewStep("max", "%d is within the max of %d", desiredReplicas, p.Max))
 	}
 
-	return int32(desiredReplicas)
+	return int32(desiredReplicas), steps
+}
+
+// backlogDetail tells what the replicas were scaled for in events.
+func backlogDetail(numMessages int) string {
+	if numMessages == UnknownBacklog {
+		return "as the backlog couldn't be read"
+	}
+	return fmt.Sprintf("for a backlog of %d messages", numMessages)
+}
+
+func scaleDirection(current, desired int32) string {
+	switch {
+	case desired > current:
+		return metrics.DirectionUp
+	case desired < current:
+		return metrics.DirectionDown
+	default:
+		return metrics.DirectionNone
+	}
 }
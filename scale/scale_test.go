@@ -4,11 +4,22 @@ import (
 	"context"
 	"testing"
 
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 
+	"kube-sqs-autoscaler/budget"
+	"kube-sqs-autoscaler/decision"
+
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	fake "k8s.io/client-go/kubernetes/fake"
+	fakeappsv1 "k8s.io/client-go/kubernetes/typed/apps/v1/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestScaleUp(t *testing.T) {
@@ -94,16 +105,98 @@ func TestScaleDownNotDoAnythingWhenDryRun(t *testing.T) {
 	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
 }
 
-func NewMockPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace string, max, min, init int) *PodAutoScaler {
+func TestScaleLogsFields(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.Log = logger.WithFields(log.Fields{"deployment": "deploy", "namespace": "namespace", "queue": "queue"})
+
+	p.Scale(context.Background(), 75)
+	assert.Equal(t, "[autoscaler] Scaling successful", hook.LastEntry().Message)
+	assert.Equal(t, log.Fields{
+		"deployment": "deploy",
+		"namespace":  "namespace",
+		"queue":      "queue",
+		"backlog":    75,
+		"current":    int32(3),
+		"desired":    int32(4),
+		"decision":   "up",
+	}, hook.LastEntry().Data)
+}
+
+func TestScaleRecordsEvents(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	recorder := record.NewFakeRecorder(10)
+	p.Recorder = recorder
+
+	p.Scale(ctx, 100)
+	assert.Equal(t, "Normal ScaledUp Scaled from 3 to 5 replicas for a backlog of 100 messages", <-recorder.Events)
+
+	p.Scale(ctx, 20)
+	assert.Equal(t, "Normal ScaledDown Scaled from 5 to 1 replicas for a backlog of 20 messages", <-recorder.Events)
+
+	// nothing changes, nothing is recorded
+	p.Scale(ctx, 20)
+	assert.Len(t, recorder.Events, 0)
+
+	p.Deployment = "missing"
+	res := p.Scale(ctx, 20)
+	assert.NotNil(t, res.Err)
+	assert.Contains(t, <-recorder.Events, "Warning ScaleFailed Failed to get deployment")
+}
+
+func TestEventsReferenceDeployment(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 3)
+	recorder := &objectRecorder{}
+	p.Recorder = recorder
+
+	// before the deployment was fetched the reference has no uid
+	p.Eventf(corev1.EventTypeWarning, ReasonQueueUnreachable, "queue unreachable")
+	ref := recorder.objects[0].(*corev1.ObjectReference)
+	assert.Equal(t, "Deployment", ref.Kind)
+	assert.Equal(t, "deploy", ref.Name)
+	assert.Equal(t, "namespace", ref.Namespace)
+	assert.Equal(t, types.UID(""), ref.UID)
+
+	p.Scale(ctx, 100)
+	ref = recorder.objects[1].(*corev1.ObjectReference)
+	assert.Equal(t, "apps/v1", ref.APIVersion)
+	assert.Equal(t, "Deployment", ref.Kind)
+	assert.Equal(t, types.UID("deploy-uid"), ref.UID)
+}
+
+// objectRecorder keeps the objects events were recorded on.
+type objectRecorder struct {
+	objects []runtime.Object
+}
+
+func (r *objectRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.objects = append(r.objects, object)
+}
+
+func (r *objectRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.objects = append(r.objects, object)
+}
+
+func (r *objectRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.objects = append(r.objects, object)
+}
+
+// NewMockPodAutoScaler returns a scaler of the deployment deploy with init
+// replicas on a fake clientset that also holds objects, see fakeClient.
+func NewMockPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace string, max, min, init int, objects ...runtime.Object) *PodAutoScaler {
 	initialReplicas := int32(init)
-	mock := fake.NewSimpleClientset(&appsv1.Deployment{
+	mock := fake.NewSimpleClientset(append(objects, &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:        "deploy",
 			Namespace:   "namespace",
+			UID:         "deploy-uid",
 			Annotations: map[string]string{},
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &initialReplicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "deploy"}},
 		},
 	}, &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -114,14 +207,49 @@ func NewMockPodAutoScaler(kubernetesDeploymentName string, kubernetesNamespace s
 		Spec: appsv1.DeploymentSpec{
 			Replicas: &initialReplicas,
 		},
-	})
-	return &PodAutoScaler{
-		Client:        mock.AppsV1().Deployments(kubernetesNamespace),
-		Min:           min,
-		Max:           max,
-		Deployment:    kubernetesDeploymentName,
-		Namespace:     kubernetesNamespace,
-		ZeroScaling:   false,
-		MessagePerPod: 20,
-	}
+	})...)
+	return NewPodAutoScaler(mock, kubernetesDeploymentName, kubernetesNamespace, max, min, 20, false, false)
+}
+
+// fakeClient returns the fake the clientset of a NewMockPodAutoScaler records
+// its actions in, e.g. to add reactors.
+func fakeClient(p *PodAutoScaler) *k8stesting.Fake {
+	return p.Client.(*fakeappsv1.FakeDeployments).Fake.Fake
+}
+
+func TestScaleWithinBudget(t *testing.T) {
+	ctx := context.Background()
+	coordinator := budget.NewCoordinator()
+	coordinator.Total.MaxPods = 6
+	other := coordinator.Join("other", "", 1, 0)
+	other.Allocate(4, 10)
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 1)
+	p.Budget = coordinator.Join("deploy", "", 1, 0)
+
+	// the share is 3 but the other deployment still has 4 replicas
+	res := p.Scale(ctx, 200)
+	assert.Equal(t, int32(2), res.DesiredReplicas)
+	assert.Contains(t, res.Steps, decision.NewStep("budget", "10 capped to 2 replicas by maxTotalPods of 6 pods"))
+	assert.Equal(t, int32(2), replicasOf(p))
+
+	other.Allocate(3, 10)
+	p.Scale(ctx, 200)
+	assert.Equal(t, int32(3), replicasOf(p))
+}
+
+func TestScaleWithinBudgetKeepsMin(t *testing.T) {
+	ctx := context.Background()
+	coordinator := budget.NewCoordinator()
+	coordinator.Total.MaxPods = 2
+	other := coordinator.Join("other", "", 1, 0)
+	other.Allocate(2, 2)
+	p := NewMockPodAutoScaler("deploy", "namespace", 10, 1, 1)
+	p.Budget = coordinator.Join("deploy", "", 1, 0)
+	setReplicas(p, 0)
+
+	// the other deployment holds the whole budget
+	res := p.Scale(ctx, 1000)
+	assert.Equal(t, int32(1), res.DesiredReplicas)
+	assert.Contains(t, res.Steps, decision.NewStep("budget", "10 capped to 0 replicas by maxTotalPods of 2 pods, raised to the min of 1"))
+	assert.Equal(t, int32(1), replicasOf(p))
 }
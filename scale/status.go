@@ -0,0 +1,136 @@
+package scale
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const AnnotationPrefix = "sqs-autoscaler/"
+
+// Annotations written on the deployment with the last scaling decision.
+const (
+	AnnotationLastBacklog         = AnnotationPrefix + "last-backlog"
+	AnnotationLastDesiredReplicas = AnnotationPrefix + "last-desired-replicas"
+	AnnotationLastScaleTime       = AnnotationPrefix + "last-scale-time"
+	AnnotationCoolDownExpiry      = AnnotationPrefix + "cooldown-expiry"
+	AnnotationInstanceId          = AnnotationPrefix + "instance-id"
+)
+
+// Annotations holding the start of the running cooldowns, see
+// PersistCoolDowns.
+const (
+	AnnotationCoolDownStarted            = AnnotationPrefix + "cooldown-started"
+	AnnotationZeroScalingCoolDownStarted = AnnotationPrefix + "zero-scaling-cooldown-started"
+)
+
+// Status is the outcome of an evaluation. Fields that are unknown, e.g. the
+// desired replicas while waiting for a cooldown, are left as they were. The
+// cooldown starts are nil while a timer isn't running.
+type Status struct {
+	Backlog                    int
+	DesiredReplicas            *int32
+	LastScaleTime              *time.Time
+	CoolDownExpiry             *time.Time
+	CoolDownStarted            *time.Time
+	ZeroScalingCoolDownStarted *time.Time
+}
+
+// annotations returns the annotations to patch, a nil value removes the
+// annotation.
+func (p *PodAutoScaler) annotations(s Status) map[string]*string {
+	a := map[string]*string{}
+	if p.StatusAnnotations {
+		a[AnnotationLastBacklog] = stringPtr(strconv.Itoa(s.Backlog))
+		a[AnnotationInstanceId] = stringPtr(p.InstanceId)
+		if s.DesiredReplicas != nil {
+			a[AnnotationLastDesiredReplicas] = stringPtr(strconv.Itoa(int(*s.DesiredReplicas)))
+		}
+		if s.LastScaleTime != nil {
+			a[AnnotationLastScaleTime] = formatTime(s.LastScaleTime)
+		}
+		if s.CoolDownExpiry != nil {
+			a[AnnotationCoolDownExpiry] = formatTime(s.CoolDownExpiry)
+		}
+	}
+	if p.PersistCoolDowns {
+		a[AnnotationCoolDownStarted] = formatTime(s.CoolDownStarted)
+		a[AnnotationZeroScalingCoolDownStarted] = formatTime(s.ZeroScalingCoolDownStarted)
+	}
+	return a
+}
+
+// WriteStatus annotates the deployment with s when StatusAnnotations or
+// PersistCoolDowns is set. The patch is skipped when nothing changed since the
+// last write.
+func (p *PodAutoScaler) WriteStatus(ctx context.Context, s Status) error {
+	if !p.StatusAnnotations && !p.PersistCoolDowns {
+		return nil
+	}
+
+	annotations := p.annotations(s)
+	if reflect.DeepEqual(annotations, p.writtenStatus) {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := p.patchDeployment(ctx, patch); err != nil {
+		return errors.Wrap(err, "Failed to write status annotations")
+	}
+	p.writtenStatus = annotations
+	return nil
+}
+
+// RestoreCoolDowns reads the cooldown starts written with PersistCoolDowns. A
+// nil start means the timer wasn't running.
+func (p *PodAutoScaler) RestoreCoolDowns(ctx context.Context) (coolDownStarted, zeroScalingCoolDownStarted *time.Time, err error) {
+	deployment, err := p.getDeployment(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to get deployment")
+	}
+
+	coolDownStarted, err = parseTime(deployment.Annotations, AnnotationCoolDownStarted)
+	if err != nil {
+		return nil, nil, err
+	}
+	zeroScalingCoolDownStarted, err = parseTime(deployment.Annotations, AnnotationZeroScalingCoolDownStarted)
+	if err != nil {
+		return nil, nil, err
+	}
+	return coolDownStarted, zeroScalingCoolDownStarted, nil
+}
+
+func parseTime(annotations map[string]string, key string) (*time.Time, error) {
+	value, ok := annotations[key]
+	if !ok {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid %s annotation", key)
+	}
+	return &t, nil
+}
+
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	return stringPtr(t.UTC().Format(time.RFC3339))
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
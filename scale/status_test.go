@@ -0,0 +1,102 @@
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWriteStatus(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	annotate(p, map[string]string{"owner": "team"})
+	p.StatusAnnotations = true
+	p.InstanceId = "pod-1"
+	desired := int32(4)
+	scaled := time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC)
+	expiry := scaled.Add(time.Minute)
+
+	err := p.WriteStatus(ctx, Status{Backlog: 75, DesiredReplicas: &desired, LastScaleTime: &scaled, CoolDownExpiry: &expiry})
+	assert.Nil(t, err)
+
+	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
+	assert.Equal(t, map[string]string{
+		"owner":                                "team",
+		"sqs-autoscaler/last-backlog":          "75",
+		"sqs-autoscaler/last-desired-replicas": "4",
+		"sqs-autoscaler/last-scale-time":       "2020-11-30T12:00:00Z",
+		"sqs-autoscaler/cooldown-expiry":       "2020-11-30T12:01:00Z",
+		"sqs-autoscaler/instance-id":           "pod-1",
+	}, deployment.Annotations)
+	assert.Equal(t, 1, countPatches(p))
+}
+
+func TestWriteStatusSkipsUnchanged(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.StatusAnnotations = true
+
+	assert.Nil(t, p.WriteStatus(ctx, Status{Backlog: 10}))
+	assert.Nil(t, p.WriteStatus(ctx, Status{Backlog: 10}))
+	assert.Equal(t, 1, countPatches(p))
+
+	assert.Nil(t, p.WriteStatus(ctx, Status{Backlog: 20}))
+	assert.Equal(t, 2, countPatches(p))
+}
+
+func TestWriteStatusDisabled(t *testing.T) {
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.StatusAnnotations = false
+
+	assert.Nil(t, p.WriteStatus(context.Background(), Status{Backlog: 10}))
+	assert.Equal(t, 0, countPatches(p))
+}
+
+func countPatches(p *PodAutoScaler) int {
+	n := 0
+	for _, action := range fakeClient(p).Actions() {
+		if action.GetVerb() == "patch" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRestoreCoolDowns(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	p.StatusAnnotations = false
+	p.PersistCoolDowns = true
+	started := time.Date(2020, 11, 30, 12, 0, 0, 0, time.UTC)
+
+	assert.Nil(t, p.WriteStatus(ctx, Status{Backlog: 10, CoolDownStarted: &started}))
+	coolDownStarted, zeroScalingCoolDownStarted, err := p.RestoreCoolDowns(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, started, *coolDownStarted)
+	assert.Nil(t, zeroScalingCoolDownStarted)
+
+	// a reset timer removes its annotation
+	assert.Nil(t, p.WriteStatus(ctx, Status{Backlog: 0, ZeroScalingCoolDownStarted: &started}))
+	coolDownStarted, zeroScalingCoolDownStarted, err = p.RestoreCoolDowns(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, coolDownStarted)
+	assert.Equal(t, started, *zeroScalingCoolDownStarted)
+
+	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
+	assert.NotContains(t, deployment.Annotations, "sqs-autoscaler/last-backlog")
+}
+
+func TestRestoreCoolDownsInvalid(t *testing.T) {
+	ctx := context.Background()
+	p := NewMockPodAutoScaler("deploy", "namespace", 5, 1, 3)
+	deployment, _ := p.Client.Get(ctx, "deploy", metav1.GetOptions{})
+	deployment.Annotations[AnnotationCoolDownStarted] = "yesterday"
+	p.Client.Update(ctx, deployment, metav1.UpdateOptions{})
+
+	_, _, err := p.RestoreCoolDowns(ctx)
+	assert.Contains(t, err.Error(), "Invalid sqs-autoscaler/cooldown-started annotation")
+}
@@ -0,0 +1,77 @@
+package sqs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	roleSessionName = "kube-sqs-autoscaler"
+	// refresh assumed role credentials a bit before they expire so a poll
+	// never runs with expired credentials
+	credentialsExpiryWindow = time.Minute
+)
+
+// credentialsKey identifies assumed role credentials. Clients for different
+// regions or endpoints assuming the same role share their credentials.
+type credentialsKey struct {
+	RoleArn              string
+	ExternalId           string
+	WebIdentityTokenFile string
+	StsEndpoint          string
+	Region               string
+}
+
+// credentialsCache holds assumed role credentials, the sdk takes care of
+// refreshing them once they are about to expire. Guarded by clientsMu.
+var credentialsCache = map[credentialsKey]*credentials.Credentials{}
+
+// credentialsFor returns the credentials for the options or nil when the
+// default credential chain should be used.
+func credentialsFor(sess *session.Session, opts ClientOptions) *credentials.Credentials {
+	if opts.RoleArn == "" {
+		return nil
+	}
+
+	key := credentialsKey{
+		RoleArn:              opts.RoleArn,
+		ExternalId:           opts.ExternalId,
+		WebIdentityTokenFile: opts.WebIdentityTokenFile,
+		StsEndpoint:          opts.StsEndpoint,
+		Region:               opts.Region,
+	}
+	if c, ok := credentialsCache[key]; ok {
+		return c
+	}
+
+	c := buildCredentials(sess, opts)
+	credentialsCache[key] = c
+	return c
+}
+
+func buildCredentials(sess *session.Session, opts ClientOptions) *credentials.Credentials {
+	cfg := aws.NewConfig().WithRegion(opts.Region)
+	if opts.StsEndpoint != "" {
+		cfg = cfg.WithEndpoint(opts.StsEndpoint)
+	}
+	svc := sts.New(sess, cfg)
+
+	if opts.WebIdentityTokenFile != "" {
+		p := stscreds.NewWebIdentityRoleProvider(svc, opts.RoleArn, roleSessionName, opts.WebIdentityTokenFile)
+		p.ExpiryWindow = credentialsExpiryWindow
+		return credentials.NewCredentials(p)
+	}
+
+	return stscreds.NewCredentialsWithClient(svc, opts.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = roleSessionName
+		p.ExpiryWindow = credentialsExpiryWindow
+		if opts.ExternalId != "" {
+			p.ExternalID = aws.String(opts.ExternalId)
+		}
+	})
+}
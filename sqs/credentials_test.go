@@ -0,0 +1,127 @@
+package sqs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSTS answers AssumeRole and AssumeRoleWithWebIdentity like STS does and
+// counts how often credentials were requested.
+type fakeSTS struct {
+	mu       sync.Mutex
+	requests []map[string]string
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	req := map[string]string{}
+	for k := range r.Form {
+		req[k] = r.Form.Get(k)
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	n := len(f.requests)
+	f.mu.Unlock()
+
+	action := req["Action"]
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>AKID%[2]d</AccessKeyId>
+      <SecretAccessKey>SECRET</SecretAccessKey>
+      <SessionToken>TOKEN</SessionToken>
+      <Expiration>%[3]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+</%[1]sResponse>`, action, n, expiration)
+}
+
+func (f *fakeSTS) Requests() []map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func TestAssumeRoleCredentials(t *testing.T) {
+	fake := &fakeSTS{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// AssumeRole itself is signed with the default credentials
+	os.Setenv("AWS_ACCESS_KEY_ID", "base-key")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "base-secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	opts := ClientOptions{
+		Region:      "us-east-1",
+		RoleArn:     "arn:aws:iam::123456789012:role/queue-reader",
+		ExternalId:  "external-id",
+		StsEndpoint: server.URL,
+	}
+	a, err := NewSqsClient(Queue{Name: "queue-a"}, opts)
+	assert.Nil(t, err)
+
+	creds, err := a.Client.(*sqs.SQS).Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKID1", creds.AccessKeyID)
+
+	// a second client with another endpoint shares the cached credentials
+	opts.Endpoint = "localhost:9324"
+	b, _ := NewSqsClient(Queue{Name: "queue-b"}, opts)
+	creds, err = b.Client.(*sqs.SQS).Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKID1", creds.AccessKeyID)
+
+	requests := fake.Requests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "AssumeRole", requests[0]["Action"])
+	assert.Equal(t, "arn:aws:iam::123456789012:role/queue-reader", requests[0]["RoleArn"])
+	assert.Equal(t, "external-id", requests[0]["ExternalId"])
+	assert.Equal(t, roleSessionName, requests[0]["RoleSessionName"])
+
+	// expired credentials are refreshed on the next use
+	a.Client.(*sqs.SQS).Config.Credentials.Expire()
+	creds, err = a.Client.(*sqs.SQS).Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKID2", creds.AccessKeyID)
+}
+
+func TestWebIdentityCredentials(t *testing.T) {
+	fake := &fakeSTS{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	tokenFile, err := ioutil.TempFile("", "token")
+	assert.Nil(t, err)
+	defer os.Remove(tokenFile.Name())
+	tokenFile.WriteString("web-identity-token")
+	tokenFile.Close()
+
+	s, err := NewSqsClient(Queue{Name: "queue"}, ClientOptions{
+		Region:               "eu-west-1",
+		RoleArn:              "arn:aws:iam::123456789012:role/web-identity",
+		WebIdentityTokenFile: tokenFile.Name(),
+		StsEndpoint:          server.URL,
+	})
+	assert.Nil(t, err)
+
+	creds, err := s.Client.(*sqs.SQS).Config.Credentials.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "AKID1", creds.AccessKeyID)
+
+	requests := fake.Requests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, "AssumeRoleWithWebIdentity", requests[0]["Action"])
+	assert.Equal(t, "web-identity-token", requests[0]["WebIdentityToken"])
+}
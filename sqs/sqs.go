@@ -1,10 +1,18 @@
 package sqs
 
 import (
-	"os"
+	"context"
 	"strconv"
+	"sync"
+	"time"
+
+	"kube-sqs-autoscaler/metrics"
+	"kube-sqs-autoscaler/retry"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 
@@ -12,73 +20,210 @@ import (
 )
 
 type SQS interface {
-	GetQueueAttributes(*sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error)
-	GetQueueUrl(*sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error)
+	GetQueueAttributesWithContext(aws.Context, *sqs.GetQueueAttributesInput, ...request.Option) (*sqs.GetQueueAttributesOutput, error)
+	GetQueueUrlWithContext(aws.Context, *sqs.GetQueueUrlInput, ...request.Option) (*sqs.GetQueueUrlOutput, error)
 }
 
 type SqsClient struct {
-	Client    SQS
-	QueueUrl  string
-	QueueName string
+	Client              SQS
+	QueueUrl            string
+	QueueName           string
+	QueueOwnerAccountId string
+	Metrics             *metrics.Scaler
+	// Retry retries the SQS calls failing with retryable errors.
+	Retry retry.Policy
+}
+
+// Queue identifies a queue by its name, url or arn. Only one of them is
+// needed, a queue given by name or arn is looked up with GetQueueUrl.
+type Queue struct {
+	Name           string
+	Url            string
+	Arn            string
+	OwnerAccountId string
+}
+
+// ClientOptions identify an SQS client. Clients are shared between all queues
+// with the same options.
+type ClientOptions struct {
+	Region   string
+	Endpoint string
+
+	// RoleArn is assumed for the SQS calls when set, either with ExternalId
+	// or with the token in WebIdentityTokenFile.
+	RoleArn              string
+	ExternalId           string
+	WebIdentityTokenFile string
+	StsEndpoint          string
 }
 
-func NewSqsClient(queue string, region string) *SqsClient {
-	svc := buildClient(region)
+// backlogAttributes are the queue attributes summed up to the backlog.
+var backlogAttributes = []string{
+	"ApproximateNumberOfMessages",
+	"ApproximateNumberOfMessagesDelayed",
+	"ApproximateNumberOfMessagesNotVisible",
+}
+
+// Backlog is a reading of the queue.
+type Backlog struct {
+	Attributes map[string]int
+	Messages   int
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[ClientOptions]*sqs.SQS{}
+	sess      *session.Session
+)
+
+func NewSqsClient(queue Queue, opts ClientOptions) (*SqsClient, error) {
+	svc := clientFor(opts)
+	name, owner := queue.Name, queue.OwnerAccountId
+	if queue.Arn != "" {
+		a, err := arn.Parse(queue.Arn)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid queue arn %s", queue.Arn)
+		}
+		name = a.Resource
+		if owner == "" {
+			owner = a.AccountID
+		}
+	}
+
 	return &SqsClient{
-		Client:    svc,
-		QueueName: queue,
-		QueueUrl:  "",
+		Client:              svc,
+		QueueName:           name,
+		QueueUrl:            queue.Url,
+		QueueOwnerAccountId: owner,
+	}, nil
+}
+
+// NumMessages returns the visible, delayed and in flight messages of the
+// queue. The SQS calls are cancelled with ctx.
+func (s *SqsClient) NumMessages(ctx context.Context) (int, error) {
+	b, err := s.ReadBacklog(ctx)
+	if err != nil {
+		return -1, err
 	}
+	return b.Messages, nil
 }
 
-func (s *SqsClient) NumMessages() (int, error) {
+// ReadBacklog reads the attributes making up the backlog of the queue.
+func (s *SqsClient) ReadBacklog(ctx context.Context) (*Backlog, error) {
 	if s.QueueUrl == "" {
-		queuUrlInput := sqs.GetQueueUrlInput{QueueName: &s.QueueName}
-		queueUrl, err := s.Client.GetQueueUrl(&queuUrlInput)
+		if err := s.resolveQueueUrl(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := s.getQueueAttributes(ctx)
+	if isQueueDoesNotExist(err) && s.QueueName != "" {
+		// the queue may have been recreated, look the url up again
+		if err := s.resolveQueueUrl(ctx); err != nil {
+			return nil, err
+		}
+		out, err = s.getQueueAttributes(ctx)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get messages in SQS")
+	}
+
+	b := &Backlog{Attributes: map[string]int{}}
+	for _, name := range backlogAttributes {
+		value, ok := out.Attributes[name]
+		if !ok || value == nil {
+			return nil, errors.Errorf("Failed to get number of messages in queue, %s is missing", name)
+		}
+		n, err := strconv.Atoi(*value)
 		if err != nil {
-			return -1, errors.Errorf("Could not fetch queue url %s", err)
+			return nil, errors.Wrap(err, "Failed to get number of messages in queue")
 		}
-		s.QueueUrl = *queueUrl.QueueUrl
+		b.Attributes[name] = n
+		b.Messages += n
 	}
 
-	params := sqs.GetQueueAttributesInput{
-		AttributeNames: []*string{
-			aws.String("ApproximateNumberOfMessages"),
-			aws.String("ApproximateNumberOfMessagesDelayed"),
-			aws.String("ApproximateNumberOfMessagesNotVisible")},
-		QueueUrl: aws.String(s.QueueUrl),
+	for name, n := range b.Attributes {
+		s.Metrics.SetQueueAttribute(name, n)
 	}
+	s.Metrics.SetBacklog(b.Messages)
+
+	return b, nil
+}
 
-	out, err := s.Client.GetQueueAttributes(&params)
+func (s *SqsClient) resolveQueueUrl(ctx context.Context) error {
+	queuUrlInput := sqs.GetQueueUrlInput{QueueName: &s.QueueName}
+	if s.QueueOwnerAccountId != "" {
+		queuUrlInput.QueueOwnerAWSAccountId = &s.QueueOwnerAccountId
+	}
+	var queueUrl *sqs.GetQueueUrlOutput
+	err := s.Retry.Do(ctx, isRetryable, s.Metrics.Attempts("GetQueueUrl"), func() (err error) {
+		defer s.Metrics.ObserveSQS("GetQueueUrl", time.Now())
+		queueUrl, err = s.Client.GetQueueUrlWithContext(ctx, &queuUrlInput)
+		return err
+	})
 	if err != nil {
-		return -1, errors.Wrap(err, "Failed to get messages in SQS")
+		return errors.Errorf("Could not fetch queue url %s", err)
 	}
+	s.QueueUrl = *queueUrl.QueueUrl
+	return nil
+}
 
-	approximateNumberOfMessages, err := strconv.Atoi(*out.Attributes["ApproximateNumberOfMessages"])
-	if err != nil {
-		return -1, errors.Wrap(err, "Failed to get number of messages in queue")
+func (s *SqsClient) getQueueAttributes(ctx context.Context) (*sqs.GetQueueAttributesOutput, error) {
+	params := sqs.GetQueueAttributesInput{
+		AttributeNames: aws.StringSlice(backlogAttributes),
+		QueueUrl:       aws.String(s.QueueUrl),
 	}
+	var out *sqs.GetQueueAttributesOutput
+	err := s.Retry.Do(ctx, isRetryable, s.Metrics.Attempts("GetQueueAttributes"), func() (err error) {
+		defer s.Metrics.ObserveSQS("GetQueueAttributes", time.Now())
+		out, err = s.Client.GetQueueAttributesWithContext(ctx, &params)
+		return err
+	})
+	return out, err
+}
 
-	approximateNumberOfMessagesDelayed, err := strconv.Atoi(*out.Attributes["ApproximateNumberOfMessagesDelayed"])
-	if err != nil {
-		return -1, errors.Wrap(err, "Failed to get number of messages in queue")
+// isRetryable tells throttling, server side and connection errors, which may
+// pass on their own, from fatal ones like a missing queue or permission.
+func isRetryable(err error) bool {
+	if isQueueDoesNotExist(err) {
+		return false
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+		return true
 	}
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
+}
 
-	approximateNumberOfMessagesNotVisible, err := strconv.Atoi(*out.Attributes["ApproximateNumberOfMessagesNotVisible"])
-	if err != nil {
-		return -1, errors.Wrap(err, "Failed to get number of messages in queue")
+func isQueueDoesNotExist(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == sqs.ErrCodeQueueDoesNotExist
 	}
+	return false
+}
 
-	messages := approximateNumberOfMessages + approximateNumberOfMessagesDelayed + approximateNumberOfMessagesNotVisible
+func clientFor(opts ClientOptions) *sqs.SQS {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
 
-	return messages, nil
+	if c, ok := clients[opts]; ok {
+		return c
+	}
+	if sess == nil {
+		sess = session.Must(session.NewSession())
+	}
+	c := buildClient(sess, opts)
+	clients[opts] = c
+	return c
 }
 
-func buildClient(region string) *sqs.SQS {
-	endpoint := os.Getenv("AWS_ENDPOINT")
-	cfg := aws.NewConfig().WithRegion(region)
-	if endpoint != "" {
-		cfg = cfg.WithEndpoint(endpoint).WithDisableSSL(true)
+func buildClient(sess *session.Session, opts ClientOptions) *sqs.SQS {
+	// failed calls are retried with the Retry policy of the SqsClient
+	cfg := aws.NewConfig().WithRegion(opts.Region).WithMaxRetries(0)
+	if opts.Endpoint != "" {
+		cfg = cfg.WithEndpoint(opts.Endpoint).WithDisableSSL(true)
+	}
+	if creds := credentialsFor(sess, opts); creds != nil {
+		cfg = cfg.WithCredentials(creds)
 	}
-	return sqs.New(session.Must(session.NewSession()), cfg)
+	return sqs.New(sess, cfg)
 }
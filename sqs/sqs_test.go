@@ -1,9 +1,15 @@
 package sqs
 
 import (
+	"context"
 	"testing"
+	"time"
+
+	"kube-sqs-autoscaler/retry"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/stretchr/testify/assert"
 )
@@ -11,21 +17,118 @@ import (
 func TestNumMessages(t *testing.T) {
 	s := NewMockSqsClient()
 
-	num, err := s.NumMessages()
+	num, err := s.NumMessages(context.Background())
+	assert.Equal(t, 30, num)
+	assert.Nil(t, err)
+}
+
+func TestNumMessagesWithQueueUrlSkipsLookup(t *testing.T) {
+	s := NewMockSqsClient()
+	s.QueueName = ""
+	s.QueueUrl = "https://sqs.us-east-1.amazonaws.com/123456789012/example-queue"
+
+	num, err := s.NumMessages(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 30, num)
+	assert.Equal(t, 0, s.Client.(*MockSQS).GetQueueUrlCalls)
+}
+
+func TestNumMessagesWithQueueArn(t *testing.T) {
+	s, err := NewSqsClient(Queue{Arn: "arn:aws:sqs:us-east-1:123456789012:example-queue"}, ClientOptions{Region: "us-east-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "example-queue", s.QueueName)
+	assert.Equal(t, "123456789012", s.QueueOwnerAccountId)
+
+	mock := NewMockSqsClient().Client.(*MockSQS)
+	s.Client = mock
+	_, err = s.NumMessages(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012", *mock.LastGetQueueUrlInput.QueueOwnerAWSAccountId)
+
+	_, err = NewSqsClient(Queue{Arn: "example-queue"}, ClientOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestNumMessagesResolvesRecreatedQueue(t *testing.T) {
+	s := NewMockSqsClient()
+	s.QueueUrl = "example.com/old"
+	mock := s.Client.(*MockSQS)
+	mock.MissingQueueUrls = map[string]bool{"example.com/old": true}
+
+	num, err := s.NumMessages(context.Background())
+	assert.Nil(t, err)
 	assert.Equal(t, 30, num)
+	assert.Equal(t, "example.com", s.QueueUrl)
+	assert.Equal(t, 1, mock.GetQueueUrlCalls)
+}
+
+func TestNumMessagesDoesNotResolveStaticQueueUrl(t *testing.T) {
+	s := NewMockSqsClient()
+	s.QueueName = ""
+	s.QueueUrl = "example.com/old"
+	mock := s.Client.(*MockSQS)
+	mock.MissingQueueUrls = map[string]bool{"example.com/old": true}
+
+	_, err := s.NumMessages(context.Background())
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, mock.GetQueueUrlCalls)
+}
+
+func TestNumMessagesRetriesRetryableErrors(t *testing.T) {
+	s := NewMockSqsClient()
+	s.Retry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	mock := s.Client.(*MockSQS)
+	mock.Errs = []error{
+		awserr.New("ThrottlingException", "Rate exceeded", nil),
+		awserr.NewRequestFailure(awserr.New("InternalError", "We encountered an internal error", nil), 500, "request-id"),
+	}
+
+	num, err := s.NumMessages(context.Background())
 	assert.Nil(t, err)
+	assert.Equal(t, 30, num)
+	assert.Equal(t, 3, mock.GetAttributesCalls)
+}
+
+func TestNumMessagesDoesNotRetryFatalErrors(t *testing.T) {
+	s := NewMockSqsClient()
+	s.Retry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	mock := s.Client.(*MockSQS)
+	mock.Errs = []error{
+		awserr.NewRequestFailure(awserr.New("AccessDenied", "Access to the resource is denied", nil), 403, "request-id"),
+	}
+
+	_, err := s.NumMessages(context.Background())
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, mock.GetAttributesCalls)
 }
 
 type MockSQS struct {
-	QueueAttributes *sqs.GetQueueAttributesOutput
-	QueueUrl        *sqs.GetQueueUrlOutput
+	QueueAttributes      *sqs.GetQueueAttributesOutput
+	QueueUrl             *sqs.GetQueueUrlOutput
+	MissingQueueUrls     map[string]bool
+	GetQueueUrlCalls     int
+	LastGetQueueUrlInput *sqs.GetQueueUrlInput
+	// Errs are returned by the next GetQueueAttributes calls, one per call.
+	Errs               []error
+	GetAttributesCalls int
 }
 
-func (m *MockSQS) GetQueueAttributes(*sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+func (m *MockSQS) GetQueueAttributesWithContext(ctx aws.Context, in *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+	m.GetAttributesCalls++
+	if len(m.Errs) > 0 {
+		err := m.Errs[0]
+		m.Errs = m.Errs[1:]
+		return nil, err
+	}
+	if m.MissingQueueUrls[*in.QueueUrl] {
+		return nil, awserr.New(sqs.ErrCodeQueueDoesNotExist, "The specified queue does not exist", nil)
+	}
 	return m.QueueAttributes, nil
 }
 
-func (m *MockSQS) GetQueueUrl(*sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+func (m *MockSQS) GetQueueUrlWithContext(ctx aws.Context, in *sqs.GetQueueUrlInput, opts ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	m.GetQueueUrlCalls++
+	m.LastGetQueueUrlInput = in
 	return m.QueueUrl, nil
 }
 
@@ -47,3 +150,15 @@ func NewMockSqsClient() *SqsClient {
 		QueueName: "example-queue",
 	}
 }
+
+func TestClientsAreSharedPerRegionAndEndpoint(t *testing.T) {
+	a, _ := NewSqsClient(Queue{Name: "queue-a"}, ClientOptions{Region: "us-east-1"})
+	b, _ := NewSqsClient(Queue{Name: "queue-b"}, ClientOptions{Region: "us-east-1"})
+	c, _ := NewSqsClient(Queue{Name: "queue-c"}, ClientOptions{Region: "eu-west-1"})
+	d, _ := NewSqsClient(Queue{Name: "queue-d"}, ClientOptions{Region: "us-east-1", Endpoint: "localhost:9324"})
+
+	assert.Same(t, a.Client, b.Client)
+	assert.NotSame(t, a.Client, c.Client)
+	assert.NotSame(t, a.Client, d.Client)
+	assert.Equal(t, "http://localhost:9324", d.Client.(*sqs.SQS).Endpoint)
+}